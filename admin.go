@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// requireAdmin guards next behind ADMIN_TOKEN, sent as a bearer token or
+// X-Admin-Token. Admin endpoints are disabled when no token is configured.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			writeErrorStatus(w, r, http.StatusUnauthorized, "admin token required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func isAdmin(r *http.Request) bool {
+	want := os.Getenv("ADMIN_TOKEN")
+	if want == "" {
+		return false
+	}
+
+	got := r.Header.Get("X-Admin-Token")
+	if got == "" {
+		got = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// isPriority reports whether r may skip the public throttles (daily quota
+// and the stream cap): it carries PRIORITY_TOKEN in X-Priority-Token, or
+// is an admin request.
+func isPriority(r *http.Request) bool {
+	if want := os.Getenv("PRIORITY_TOKEN"); want != "" {
+		got := r.Header.Get("X-Priority-Token")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1 {
+			return true
+		}
+	}
+	return isAdmin(r)
+}
+
+// ServerConfig is the effective non-secret configuration. Never add API
+// keys or tokens here.
+type ServerConfig struct {
+	BaseURL              string    `json:"base_url"`
+	CompletionsURL       string    `json:"completions_url"`
+	Defaults             GenParams `json:"defaults"`
+	Personas             []string  `json:"personas"`
+	DefaultPersona       string    `json:"default_persona"`
+	AllowedOrigins       []string  `json:"allowed_origins"`
+	RingCapacity         int       `json:"ring_capacity"`
+	MaxStatelessMessages int       `json:"max_stateless_messages"`
+	MaxStatelessTokens   int       `json:"max_stateless_tokens"`
+	CollapseInFlight     bool      `json:"collapse_inflight"`
+	AllowNoSystem        bool      `json:"allow_no_system"`
+	EnforceOneLineRetry  bool      `json:"enforce_oneline_retry"`
+	TempAnneal           bool      `json:"temp_anneal"`
+	SSEKeepAlive         string    `json:"sse_keepalive_interval"`
+	SessionStore         string    `json:"session_store"`
+}
+
+func currentConfig() ServerConfig {
+	names := make([]string, 0, len(personas))
+	for name := range personas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sessionStore := "memory"
+	if _, ok := store.(*redisStore); ok {
+		sessionStore = "redis"
+	}
+
+	return ServerConfig{
+		BaseURL:              redactURL(envString("CEREBRAS_BASE_URL", "https://api.cerebras.ai")),
+		CompletionsURL:       redactURL(completionsURL()),
+		Defaults:             defaultParams,
+		Personas:             names,
+		DefaultPersona:       defaultPersona,
+		AllowedOrigins:       allowedOrigins,
+		RingCapacity:         envInt("RING_CAPACITY", 10),
+		MaxStatelessMessages: envInt("MAX_STATELESS_MESSAGES", 50),
+		MaxStatelessTokens:   envInt("MAX_STATELESS_TOKENS", 8000),
+		CollapseInFlight:     envBool("COLLAPSE_INFLIGHT", false),
+		AllowNoSystem:        envBool("ALLOW_NO_SYSTEM", false),
+		EnforceOneLineRetry:  envBool("ENFORCE_ONELINE_RETRY", false),
+		TempAnneal:           envBool("TEMP_ANNEAL", false),
+		SSEKeepAlive:         envDuration("SSE_KEEPALIVE_INTERVAL", 15*time.Second).String(),
+		SessionStore:         sessionStore,
+	}
+}
+
+// redactURL masks any userinfo password embedded in raw.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "<invalid>"
+	}
+	return u.Redacted()
+}
+
+// handleConfig returns the effective non-secret configuration.
+func handleConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, currentConfig())
+}
+
+// SessionSummary describes a session without exposing its contents.
+type SessionSummary struct {
+	ID         string     `json:"id"`
+	Messages   int        `json:"messages"`
+	LastActive *time.Time `json:"last_active,omitempty"`
+	// Busy marks a session with a turn in flight; its counts are omitted
+	// rather than waiting for the turn to finish.
+	Busy bool `json:"busy,omitempty"`
+}
+
+// handleSessions lists active sessions with their message counts and last
+// activity, sorted by ID.
+func handleSessions(w http.ResponseWriter, r *http.Request) {
+	ids, err := store.List()
+	if err != nil {
+		writeErrorStatus(w, r, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	out := make([]SessionSummary, 0, len(ids))
+	for _, id := range ids {
+		s, ok, err := store.Get(id)
+		if err != nil {
+			writeErrorStatus(w, r, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		if !ok {
+			continue // deleted since List
+		}
+		sum := SessionSummary{ID: id}
+		if s.mu.TryRLock() {
+			sum.Messages = s.history.len()
+			lastActive := s.lastActive
+			sum.LastActive = &lastActive
+			s.mu.RUnlock()
+		} else {
+			sum.Busy = true
+		}
+		out = append(out, sum)
+	}
+	writeJSON(w, r, http.StatusOK, out)
+}
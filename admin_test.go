@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func adminRequest(t *testing.T, method, url string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Admin-Token", "admin-secret")
+	return req
+}
+
+func TestConfigOmitsSecrets(t *testing.T) {
+	srv, upstream := newTestServer(t)
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	t.Setenv("CEREBRAS_API_KEY", "csk-very-secret")
+	t.Setenv("CEREBRAS_BASE_URL", strings.Replace(upstream.URL, "http://", "http://user:hunter2@", 1))
+
+	if resp, _ := do(t, "GET", srv.URL+"/api/config", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without token: status = %d, want 401", resp.StatusCode)
+	}
+
+	resp, body := send(t, adminRequest(t, "GET", srv.URL+"/api/config"))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+	}
+	for _, secret := range []string{"csk-very-secret", "hunter2", "admin-secret"} {
+		if strings.Contains(string(body), secret) {
+			t.Errorf("config leaks %q:\n%s", secret, body)
+		}
+	}
+	if !strings.Contains(string(body), `"model":"gpt-oss-120b"`) {
+		t.Errorf("config lacks the default model:\n%s", body)
+	}
+}
+
+func TestAdminSessions(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Secret reply."})
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "b", Message: "private one"})
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "b", Message: "private two"})
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "a", Message: "private three"})
+
+	if resp, _ := do(t, "GET", srv.URL+"/admin/sessions", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without token: status = %d, want 401", resp.StatusCode)
+	}
+	resp, body := send(t, adminRequest(t, "GET", srv.URL+"/admin/sessions"))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+	}
+	if bytes.Contains(body, []byte("private")) || bytes.Contains(body, []byte("Secret")) {
+		t.Errorf("summary exposes message content: %s", body)
+	}
+	var sums []SessionSummary
+	json.Unmarshal(body, &sums)
+	if len(sums) != 2 || sums[0].ID != "a" || sums[0].Messages != 2 || sums[1].ID != "b" || sums[1].Messages != 4 {
+		t.Fatalf("summaries = %+v, want a with 2 messages then b with 4", sums)
+	}
+	if sums[0].LastActive == nil || time.Since(*sums[0].LastActive) > time.Minute {
+		t.Errorf("last_active = %v", sums[0].LastActive)
+	}
+
+	// a session mid-turn is reported busy rather than waited on
+	upstream.Enqueue(cerebrastest.Response{Content: "Slow.", Delay: 300 * time.Millisecond})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if resp, err := http.Post(srv.URL+"/api/chat", "application/json", strings.NewReader(`{"session_id": "a", "message": "slow"}`)); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	defer func() { <-done }()
+	waitFor(t, "the slow turn to reach upstream", func() bool { return len(upstream.Requests()) == 4 })
+	_, body = send(t, adminRequest(t, "GET", srv.URL+"/admin/sessions"))
+	sums = nil
+	json.Unmarshal(body, &sums)
+	if len(sums) != 2 || !sums[0].Busy || sums[0].LastActive != nil {
+		t.Errorf("summaries = %+v, want a busy", sums)
+	}
+}
+
+func TestPriorityBypass(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+	setVar(t, &quota, newDailyQuota(1, time.Now))
+	t.Setenv("PRIORITY_TOKEN", "vip")
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+
+	chat := func(path string, headers map[string]string) int {
+		req, _ := http.NewRequest("POST", srv.URL+path, strings.NewReader(`{"session_id": "vip", "message": "hi"}`))
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, _ := send(t, req)
+		return resp.StatusCode
+	}
+
+	chat("/api/chat", nil)
+	for name, tc := range map[string]struct {
+		headers map[string]string
+		status  int
+	}{
+		"no token":       {nil, http.StatusTooManyRequests},
+		"wrong token":    {map[string]string{"X-Priority-Token": "guess"}, http.StatusTooManyRequests},
+		"priority token": {map[string]string{"X-Priority-Token": "vip"}, http.StatusOK},
+		"admin token":    {map[string]string{"X-Admin-Token": "admin-secret"}, http.StatusOK},
+	} {
+		if status := chat("/api/chat", tc.headers); status != tc.status {
+			t.Errorf("quota used up, %s: status = %d, want %d", name, status, tc.status)
+		}
+	}
+
+	// the stream cap
+	setVar(t, &quota, newDailyQuota(0, time.Now))
+	t.Setenv("MAX_CONCURRENT_STREAMS", "1")
+	upstream.Enqueue(cerebrastest.Response{Stream: []string{"Slow ", "stream."}, ChunkDelay: 200 * time.Millisecond})
+	wait := openSlowStream(t, srv.URL, "first")
+	defer wait()
+	upstream.Enqueue(cerebrastest.Response{Stream: []string{"Fast."}})
+	if status := chat("/api/chat/stream", nil); status != http.StatusServiceUnavailable {
+		t.Errorf("streams full, no token: status = %d, want 503", status)
+	}
+	if status := chat("/api/chat/stream", map[string]string{"X-Priority-Token": "vip"}); status != http.StatusOK {
+		t.Errorf("streams full, priority token: status = %d, want 200", status)
+	}
+
+	// browsers may send the token only if the preflight allows it
+	req, _ := http.NewRequest("OPTIONS", srv.URL+"/api/chat", nil)
+	req.Header.Set("Origin", "https://dibinxavier.github.io")
+	req.Header.Set("Access-Control-Request-Headers", "x-priority-token")
+	resp, _ := send(t, req)
+	if got := resp.Header.Get("Access-Control-Allow-Headers"); !strings.Contains(got, "X-Priority-Token") {
+		t.Errorf("preflight Allow-Headers = %q, want X-Priority-Token", got)
+	}
+}
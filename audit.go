@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// auditRecord is one completed turn, appended as a JSON line to
+// AUDIT_LOG_PATH.
+type auditRecord struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	SessionID string    `json:"session_id,omitempty"`
+	Endpoint  string    `json:"endpoint"`
+	Message   string    `json:"message"`
+	Reply     string    `json:"reply"`
+}
+
+var audit struct {
+	mu   sync.Mutex
+	once sync.Once
+	f    *os.File
+}
+
+// writeAudit appends rec to the audit log, if AUDIT_LOG_PATH is set. With
+// AUDIT_REDACT_PII on, emails, card numbers and phone numbers are masked
+// first; the live reply is never affected.
+func writeAudit(ctx context.Context, rec auditRecord) {
+	path := os.Getenv("AUDIT_LOG_PATH")
+	if path == "" {
+		return
+	}
+
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+
+	audit.once.Do(func() {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			logger.Error("audit log open failed", "path", path, "error", err)
+			return
+		}
+		audit.f = f
+	})
+	if audit.f == nil {
+		return
+	}
+
+	rec.Time = time.Now().UTC()
+	rec.RequestID = requestIDFrom(ctx)
+	if envBool("AUDIT_REDACT_PII", false) {
+		rec.Message = redactPII(rec.Message)
+		rec.Reply = redactPII(rec.Reply)
+	}
+	line, _ := json.Marshal(rec)
+	if _, err := audit.f.Write(append(line, '\n')); err != nil {
+		logger.Error("audit log write failed", "error", err)
+	}
+}
+
+// closeAudit syncs and closes the audit log on shutdown.
+func closeAudit() error {
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+
+	if audit.f == nil {
+		return nil
+	}
+	if err := audit.f.Sync(); err != nil {
+		return err
+	}
+	err := audit.f.Close()
+	audit.f = nil
+	return err
+}
+
+// piiPatterns are applied in order; card numbers go before phone numbers
+// since the phone pattern would otherwise swallow them.
+var piiPatterns = []struct {
+	re   *regexp.Regexp
+	mask string
+}{
+	{regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), "[EMAIL]"},
+	{regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), "[CARD]"},
+	{regexp.MustCompile(`\+?\d[\d ().-]{6,}\d`), "[PHONE]"},
+}
+
+func redactPII(s string) string {
+	for _, p := range piiPatterns {
+		s = p.re.ReplaceAllString(s, p.mask)
+	}
+	return s
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+// withAuditLog points AUDIT_LOG_PATH at a fresh file for the test and
+// returns its path.
+func withAuditLog(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	t.Setenv("AUDIT_LOG_PATH", path)
+	reset := func() {
+		closeAudit()
+		audit.once = sync.Once{}
+	}
+	reset()
+	t.Cleanup(reset)
+	return path
+}
+
+// auditRecords reads back the records in the audit log at path.
+func auditRecords(t *testing.T, path string) []auditRecord {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var out []auditRecord
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var rec auditRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("bad audit line %q: %v", sc.Text(), err)
+		}
+		out = append(out, rec)
+	}
+	return out
+}
+
+func TestAuditFlushedOnShutdown(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Last words.", Delay: 100 * time.Millisecond})
+	path := withAuditLog(t)
+
+	done := make(chan int)
+	go func() {
+		resp, err := http.Post(srv.URL+"/api/chat", "application/json",
+			strings.NewReader(`{"session_id": "audited", "message": "hi"}`))
+		if err != nil {
+			done <- 0
+			return
+		}
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+	waitFor(t, "the turn to reach upstream", func() bool { return len(upstream.Requests()) == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	shutdown(ctx, srv.Config, func(context.Context) error { return nil })
+	if status := <-done; status != http.StatusOK {
+		t.Errorf("in-flight turn: status = %d, want 200", status)
+	}
+
+	recs := auditRecords(t, path)
+	if len(recs) != 1 || recs[0].SessionID != "audited" || recs[0].Reply != "Last words." {
+		t.Errorf("audit log = %+v, want the in-flight turn", recs)
+	}
+}
+
+func TestAuditRedactsPII(t *testing.T) {
+	pii := "Mail jane.doe@example.com, card 4111 1111 1111 1111, phone +1 (555) 123-4567."
+	srv, _ := newTestServer(t, cerebrastest.Response{Content: "Got it: " + pii})
+	path := withAuditLog(t)
+	t.Setenv("AUDIT_REDACT_PII", "true")
+
+	_, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "pii", Message: pii})
+	var reply ChatReply
+	json.Unmarshal(body, &reply)
+	if reply.Reply != "Got it: "+pii {
+		t.Errorf("live reply = %q, want it unredacted", reply.Reply)
+	}
+	closeAudit()
+
+	recs := auditRecords(t, path)
+	if len(recs) != 1 {
+		t.Fatalf("got %d audit records, want 1", len(recs))
+	}
+	want := "Mail [EMAIL], card [CARD], phone [PHONE]."
+	if recs[0].Message != want || recs[0].Reply != "Got it: "+want {
+		t.Errorf("audit record = %+v, want PII masked", recs[0])
+	}
+}
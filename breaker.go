@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned instead of calling upstream while the breaker
+// is open.
+var errCircuitOpen = errors.New("upstream unavailable, circuit open")
+
+// circuitBreaker stops calling Cerebras after threshold consecutive
+// failures within window, fast-failing for cooldown. After that a single
+// probe is let through: success closes the circuit, failure reopens it.
+// now is injectable so the cooldown can be exercised without waiting.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	now       func() time.Time
+
+	failures     int
+	firstFailure time.Time
+	openUntil    time.Time
+	probing      bool
+}
+
+func newCircuitBreaker(threshold int, window, cooldown time.Duration, now func() time.Time) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, window: window, cooldown: cooldown, now: now}
+}
+
+var breaker = newCircuitBreaker(
+	envInt("BREAKER_THRESHOLD", 0),
+	envDuration("BREAKER_WINDOW", 30*time.Second),
+	envDuration("BREAKER_COOLDOWN", 30*time.Second),
+	time.Now,
+)
+
+// allow reports whether an upstream call may go ahead, and if not, when to
+// try again. A threshold <= 0 disables the breaker.
+func (b *circuitBreaker) allow() (bool, time.Time) {
+	if b.threshold <= 0 {
+		return true, time.Time{}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openUntil.IsZero() {
+		return true, time.Time{}
+	}
+	if b.now().Before(b.openUntil) || b.probing {
+		return false, b.openUntil
+	}
+	// half-open: let one probe through
+	b.probing = true
+	return true, time.Time{}
+}
+
+// retryAt returns when the open circuit lets a probe through.
+func (b *circuitBreaker) retryAt() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.openUntil
+}
+
+// abandon is called when an allowed call ended without telling us anything
+// about upstream, e.g. the client went away. A pending probe slot is freed
+// so the next request can probe instead.
+func (b *circuitBreaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// record feeds the outcome of an upstream call back into the breaker.
+func (b *circuitBreaker) record(failed bool) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if !failed {
+		b.failures = 0
+		b.openUntil = time.Time{}
+		b.probing = false
+		return
+	}
+
+	if b.probing {
+		b.probing = false
+		b.openUntil = now.Add(b.cooldown)
+		logger.Warn("circuit breaker reopened", "cooldown", b.cooldown.String())
+		return
+	}
+	if b.failures == 0 || now.Sub(b.firstFailure) > b.window {
+		b.failures = 0
+		b.firstFailure = now
+	}
+	b.failures++
+	if b.failures >= b.threshold && b.openUntil.IsZero() {
+		b.openUntil = now.Add(b.cooldown)
+		logger.Warn("circuit breaker opened", "failures", b.failures, "cooldown", b.cooldown.String())
+	}
+}
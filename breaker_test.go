@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Status: http.StatusInternalServerError, Error: "down"})
+	clock := &fakeClock{t: time.Now()}
+	setVar(t, &breaker, newCircuitBreaker(3, 30*time.Second, 30*time.Second, clock.now))
+
+	chat := func() *http.Response {
+		resp, _ := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "breaker", Message: "hi"})
+		return resp
+	}
+
+	for i := 0; i < 3; i++ {
+		if resp := chat(); resp.StatusCode != http.StatusInternalServerError {
+			t.Fatalf("failure %d: status = %d", i, resp.StatusCode)
+		}
+	}
+	resp := chat()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("open circuit: status = %d, Retry-After = %q, want a 503 with Retry-After", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if n := len(upstream.Requests()); n != 3 {
+		t.Errorf("upstream got %d calls, want the open circuit to fast-fail", n)
+	}
+
+	// the probe after the cooldown fails: open again
+	clock.advance(31 * time.Second)
+	if resp := chat(); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("failed probe: status = %d", resp.StatusCode)
+	}
+	if resp := chat(); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("after a failed probe: status = %d, want 503", resp.StatusCode)
+	}
+
+	// the next probe succeeds: closed
+	clock.advance(31 * time.Second)
+	upstream.Enqueue(cerebrastest.Response{Content: "Back."})
+	for i := 0; i < 2; i++ {
+		if resp := chat(); resp.StatusCode != http.StatusOK {
+			t.Errorf("recovered, call %d: status = %d, want 200", i, resp.StatusCode)
+		}
+	}
+	if n := len(upstream.Requests()); n != 6 {
+		t.Errorf("upstream got %d calls, want 6", n)
+	}
+}
+
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	b := newCircuitBreaker(1, time.Minute, time.Minute, clock.now)
+	b.record(true)
+	if ok, at := b.allow(); ok || !at.Equal(clock.now().Add(time.Minute)) {
+		t.Errorf("open: allow = %v, %v", ok, at)
+	}
+
+	clock.advance(time.Minute)
+	if ok, _ := b.allow(); !ok {
+		t.Fatal("half-open: the probe wasn't allowed")
+	}
+	if ok, _ := b.allow(); ok {
+		t.Error("half-open: a second call went through while probing")
+	}
+	b.abandon()
+	if ok, _ := b.allow(); !ok {
+		t.Error("an abandoned probe should free the slot")
+	}
+}
+
+func TestCircuitBreakerProbeNeverSent(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Back."})
+	clock := &fakeClock{t: time.Now()}
+	setVar(t, &breaker, newCircuitBreaker(1, time.Minute, time.Minute, clock.now))
+	breaker.record(true)
+	clock.advance(time.Minute)
+
+	// the probe is granted, but the request can't even be built
+	base := upstream.URL
+	t.Setenv("CEREBRAS_BASE_URL", "http://bad host")
+	for _, path := range []string{"/api/chat", "/api/chat/stream"} {
+		do(t, "POST", srv.URL+path, ChatRequest{SessionID: "probe", Message: "hi"})
+	}
+	if n := len(upstream.Requests()); n != 0 {
+		t.Fatalf("upstream got %d calls", n)
+	}
+
+	t.Setenv("CEREBRAS_BASE_URL", base)
+	if resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "probe", Message: "hi"}); resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, body %s, want the freed probe slot to go to this call", resp.StatusCode, body)
+	}
+}
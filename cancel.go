@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// errStreamCancelled is the cause given to a stream's context when the
+// client stops it through /api/cancel.
+var errStreamCancelled = errors.New("stream cancelled by client")
+
+// errSessionReset is the cause given when the session is reset mid-stream.
+var errSessionReset = errors.New("session reset")
+
+// errSessionDeleted is the cause given when the session is deleted
+// mid-stream.
+var errSessionDeleted = errors.New("session deleted")
+
+// inflightStreams holds the cancel func of the stream running on each
+// session. Sessions run one turn at a time, so one entry per session.
+var (
+	inflightStreams   = map[string]context.CancelCauseFunc{}
+	inflightStreamsMu sync.Mutex
+)
+
+// trackStream registers cancel for the session's running stream and
+// returns a func that removes it again.
+func trackStream(id string, cancel context.CancelCauseFunc) func() {
+	inflightStreamsMu.Lock()
+	inflightStreams[id] = cancel
+	inflightStreamsMu.Unlock()
+
+	return func() {
+		inflightStreamsMu.Lock()
+		delete(inflightStreams, id)
+		inflightStreamsMu.Unlock()
+	}
+}
+
+// cancelStream stops the stream running on session id, if any, with cause.
+func cancelStream(id string, cause error) {
+	inflightStreamsMu.Lock()
+	cancel, ok := inflightStreams[sessionKey(id)]
+	inflightStreamsMu.Unlock()
+	if ok {
+		cancel(cause)
+	}
+}
+
+// CancelRequest names the session to act on for /api/cancel and
+// /api/reset.
+type CancelRequest struct {
+	SessionID string `json:"session_id"`
+}
+
+// handleCancel stops the stream in flight on a session, for clients that
+// can't cleanly close the SSE connection. The stream ends with a
+// "cancelled" event carrying the partial reply, which is kept.
+func handleCancel(w http.ResponseWriter, r *http.Request) {
+	var req CancelRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeErrorStatus(w, r, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	req.SessionID = withCookieSession(w, r, req.SessionID)
+
+	inflightStreamsMu.Lock()
+	cancel, ok := inflightStreams[sessionKey(req.SessionID)]
+	inflightStreamsMu.Unlock()
+	if !ok {
+		writeErrorStatus(w, r, http.StatusNotFound, "no stream in progress")
+		return
+	}
+	cancel(errStreamCancelled)
+	w.WriteHeader(http.StatusNoContent)
+}
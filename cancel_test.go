@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestCancelStream(t *testing.T) {
+	deltas := make([]string, 20)
+	for i := range deltas {
+		deltas[i] = "word "
+	}
+	srv, _ := newTestServer(t, cerebrastest.Response{Stream: deltas, ChunkDelay: 50 * time.Millisecond})
+
+	if resp, _ := do(t, "POST", srv.URL+"/api/cancel", CancelRequest{SessionID: "long"}); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("cancel with nothing running: status = %d, want 404", resp.StatusCode)
+	}
+
+	start := time.Now()
+	resp, err := http.Post(srv.URL+"/api/chat/stream", "application/json", strings.NewReader(`{"session_id": "long", "message": "ramble"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+	if _, err := r.ReadString('\n'); err != nil {
+		t.Fatalf("reading the first delta: %v", err)
+	}
+
+	if resp, _ := do(t, "POST", srv.URL+"/api/cancel", CancelRequest{SessionID: "long"}); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("cancel: status = %d, want 204", resp.StatusCode)
+	}
+	rest, _ := io.ReadAll(r)
+	// the full stream would take a second
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("stream ran for %s after the cancel", d)
+	}
+
+	events := parseSSE(string(rest))
+	if len(events) < 2 || events[len(events)-2].name != "cancelled" {
+		t.Fatalf("stream didn't end with a cancelled event:\n%s", rest)
+	}
+	var done ChatReply
+	json.Unmarshal([]byte(events[len(events)-1].data), &done)
+	if done.Reply == "" || !done.Truncated {
+		t.Errorf("done = %+v, want the partial reply flagged truncated", done)
+	}
+	if resp, _ := do(t, "POST", srv.URL+"/api/cancel", CancelRequest{SessionID: "long"}); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("cancel after the stream ended: status = %d, want 404", resp.StatusCode)
+	}
+}
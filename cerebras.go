@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// warmup opens a connection to Cerebras with a cheap models-list call so
+// the first real request doesn't pay for DNS, TCP and TLS setup. Failures
+// are only logged.
+func warmup(ctx context.Context) {
+	base := envString("CEREBRAS_BASE_URL", "https://api.cerebras.ai")
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", strings.TrimRight(base, "/")+"/v1/models", nil)
+	if err != nil {
+		logger.Warn("warmup failed", "error", err)
+		return
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+os.Getenv("CEREBRAS_API_KEY"))
+
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		logger.Warn("warmup failed", "error", err)
+		return
+	}
+	closeBody(resp)
+	logger.Info("warmup done", "status", resp.StatusCode, "latency_ms", time.Since(start).Milliseconds())
+}
+
+// completionsURL joins CEREBRAS_BASE_URL and CEREBRAS_COMPLETIONS_PATH,
+// so compatible endpoints or newer API versions need no code change.
+func completionsURL() string {
+	base := envString("CEREBRAS_BASE_URL", "https://api.cerebras.ai")
+	path := envString("CEREBRAS_COMPLETIONS_PATH", "/v1/chat/completions")
+	return strings.TrimRight(base, "/") + "/" + strings.TrimLeft(path, "/")
+}
+
+// upstreamMessage is the wire form of a Message: only the fields
+// Cerebras understands, never our bookkeeping.
+type upstreamMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// completionPayload builds the request body sent to Cerebras for msgs,
+// with any system messages collapsed into a single leading one and
+// max_tokens clamped to the model's limit. With MERGE_CONSECUTIVE_ROLES
+// on, back-to-back messages from the same role are joined.
+func completionPayload(msgs []Message, params GenParams, stream bool) map[string]interface{} {
+	msgs = singleSystem(msgs)
+	if envBool("MERGE_CONSECUTIVE_ROLES", false) {
+		msgs = mergeConsecutive(msgs)
+	}
+	params = clampMaxTokens(params)
+	wire := make([]upstreamMessage, len(msgs))
+	for i, m := range msgs {
+		wire[i] = upstreamMessage{Role: m.Role, Content: m.Content}
+	}
+
+	payload := map[string]interface{}{
+		"model":    params.Model,
+		"messages": wire,
+	}
+	if params.Temperature != nil {
+		payload["temperature"] = *params.Temperature
+	}
+	if params.TopP != nil {
+		payload["top_p"] = *params.TopP
+	}
+	if params.MaxTokens > 0 {
+		payload["max_tokens"] = params.MaxTokens
+	}
+	// models that don't know top_k/min_p would reject the whole request
+	if modelProfiles[params.Model].TopKMinP {
+		if params.TopK != nil {
+			payload["top_k"] = *params.TopK
+		}
+		if params.MinP != nil {
+			payload["min_p"] = *params.MinP
+		}
+	}
+	if params.ResponseFormat != nil {
+		payload["response_format"] = params.ResponseFormat
+	}
+	if params.User != "" {
+		payload["user"] = params.User
+	}
+	if stream {
+		payload["stream"] = true
+		// ask for a final chunk carrying token usage
+		payload["stream_options"] = map[string]bool{"include_usage": true}
+	}
+	return payload
+}
+
+// complete sends a non-streaming completion for msgs and returns the parsed
+// response. Network errors, 429s and 5xx responses are retried up to
+// UPSTREAM_RETRIES times, backing off UPSTREAM_RETRY_BACKOFF longer each
+// time. Errors are prefixed for display to the client.
+func complete(ctx context.Context, msgs []Message, params GenParams) (apiRes *ChatResponse, err error) {
+	ctx, span := startUpstreamSpan(ctx, params, false)
+	status := 0
+	defer func() {
+		var usage Usage
+		if apiRes != nil {
+			usage = apiRes.Usage
+		}
+		recordUsage(usage)
+		endUpstreamSpan(span, status, usage, err)
+	}()
+
+	maxRetries := envInt("UPSTREAM_RETRIES", 0)
+	backoff := envDuration("UPSTREAM_RETRY_BACKOFF", 200*time.Millisecond)
+	for attempt := 0; ; attempt++ {
+		if ok, _ := breaker.allow(); !ok {
+			return nil, errCircuitOpen
+		}
+		apiRes, status, err = completeOnce(ctx, msgs, params)
+		if err == nil {
+			apiRes.retries = attempt
+			if envBool("VALIDATE_MODEL_ECHO", false) {
+				checkEcho(ctx, apiRes, params.Model)
+			}
+			return apiRes, nil
+		}
+		if attempt >= maxRetries || !retryableStatus(status) {
+			return nil, &retriedError{err: err, retries: attempt}
+		}
+		select {
+		case <-ctx.Done():
+			// report the deadline, not the failure that led to the backoff,
+			// so the client gets a 504
+			return nil, &retriedError{err: fmt.Errorf("%w (last error: %v)", ctx.Err(), err), retries: attempt}
+		case <-time.After(backoff * time.Duration(attempt+1)):
+		}
+	}
+}
+
+// checkEcho warns when a response doesn't say it is a chat completion from
+// the model we asked for; a different model usually means upstream fell
+// back to another one.
+func checkEcho(ctx context.Context, res *ChatResponse, model string) {
+	if res.Object != "" && res.Object != "chat.completion" {
+		logger.Warn("unexpected response object", "request_id", requestIDFrom(ctx), "object", res.Object)
+	}
+	if res.Model != "" && model != "" && res.Model != model {
+		logger.Warn("upstream answered with a different model", "request_id", requestIDFrom(ctx),
+			"requested", model, "actual", res.Model)
+	}
+}
+
+// echoedModel is the model upstream says produced res, surfaced in replies
+// when VALIDATE_MODEL_ECHO is on.
+func echoedModel(res *ChatResponse) string {
+	if !envBool("VALIDATE_MODEL_ECHO", false) {
+		return ""
+	}
+	return res.Model
+}
+
+// retryableStatus reports whether an upstream failure is worth retrying: a
+// transport error (status 0), rate limiting, or a server-side error.
+func retryableStatus(status int) bool {
+	return status == 0 || status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// retriedError is the last failure of a completion, along with how many
+// retries came before it.
+type retriedError struct {
+	err     error
+	retries int
+}
+
+func (e *retriedError) Error() string { return e.err.Error() }
+func (e *retriedError) Unwrap() error { return e.err }
+
+// retriesOf returns the number of retries behind err, and false if err
+// didn't come from complete.
+func retriesOf(err error) (int, bool) {
+	var re *retriedError
+	if errors.As(err, &re) {
+		return re.retries, true
+	}
+	return 0, false
+}
+
+// completeOnce makes a single completion call, returning the upstream
+// status alongside any error, and reports how upstream did to the breaker.
+func completeOnce(ctx context.Context, msgs []Message, params GenParams) (*ChatResponse, int, error) {
+	httpReq, err := newCompletionRequest(ctx, completionPayload(msgs, params, false))
+	if err != nil {
+		// upstream was never asked
+		breaker.abandon()
+		return nil, 0, fmt.Errorf("Request creation error: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		recordOutcome(ctx, 0)
+		return nil, 0, fmt.Errorf("API call error: %w", err)
+	}
+	defer closeBody(resp)
+	// a 200 whose body then fails to read or parse still means upstream
+	// answered; only the status counts against it
+	recordOutcome(ctx, resp.StatusCode)
+
+	reader, err := decodedBody(resp)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("Read response error: %w", err)
+	}
+	body, err := readUpstream(reader)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("Read response error: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, &upstreamError{
+			err:        fmt.Errorf("API error (%s): %s", resp.Status, body),
+			rateLimits: upstreamRateLimits(resp.Header),
+		}
+	}
+
+	var parsed ChatResponse
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("Unmarshal error: %w", err)
+	}
+	if len(parsed.Choices) == 0 {
+		return nil, resp.StatusCode, fmt.Errorf("API returned no choices")
+	}
+	// a missing, null or {} message has neither role nor content
+	if m := parsed.Choices[0].Message; m.Role == "" && m.Content == "" {
+		return nil, resp.StatusCode, fmt.Errorf("API returned an empty message")
+	}
+	parsed.rateLimits = upstreamRateLimits(resp.Header)
+	return &parsed, resp.StatusCode, nil
+}
+
+// openStream starts a streaming completion and returns the response once
+// upstream has accepted it. The caller must close the body.
+func openStream(ctx context.Context, msgs []Message, params GenParams) (*http.Response, error) {
+	if ok, _ := breaker.allow(); !ok {
+		return nil, errCircuitOpen
+	}
+
+	httpReq, err := newCompletionRequest(ctx, completionPayload(msgs, params, true))
+	if err != nil {
+		// upstream was never asked
+		breaker.abandon()
+		return nil, fmt.Errorf("Request creation error: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		recordOutcome(ctx, 0)
+		return nil, fmt.Errorf("API call error: %w", err)
+	}
+
+	recordOutcome(ctx, resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := readUpstream(resp.Body)
+		closeBody(resp)
+		return nil, fmt.Errorf("API error (%s): %s", resp.Status, body)
+	}
+	return resp, nil
+}
+
+// errUpstreamTooLarge is returned for upstream bodies over
+// MAX_UPSTREAM_BYTES.
+var errUpstreamTooLarge = errors.New("upstream response too large")
+
+// readUpstream reads a whole upstream body, but no more than
+// MAX_UPSTREAM_BYTES of it, so a misbehaving upstream can't exhaust our
+// memory. The limit applies after decompression.
+func readUpstream(r io.Reader) ([]byte, error) {
+	max := int64(envInt("MAX_UPSTREAM_BYTES", 4<<20))
+	body, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > max {
+		return nil, fmt.Errorf("%w: over %d bytes", errUpstreamTooLarge, max)
+	}
+	return body, nil
+}
+
+// recordOutcome tells the circuit breaker how an upstream call went, by
+// its status (0 for a transport error). Calls cut short by our own context
+// say nothing about upstream health, and client errors (4xx other than
+// 429) don't count as failures.
+func recordOutcome(ctx context.Context, status int) {
+	if ctx.Err() != nil {
+		breaker.abandon()
+		return
+	}
+	breaker.record(retryableStatus(status))
+}
+
+// maxDrainBytes bounds how much of an unread body we'll discard to keep
+// the connection reusable; anything bigger is cheaper to just drop.
+const maxDrainBytes = 64 << 10
+
+// closeBody drains a bounded amount of what's left of resp's body and
+// closes it, so the keep-alive connection can go back to the pool even on
+// early-exit paths. Safe on nil responses and bodies.
+func closeBody(resp *http.Response) {
+	if resp == nil || resp.Body == nil {
+		return
+	}
+	io.CopyN(io.Discard, resp.Body, maxDrainBytes)
+	if err := resp.Body.Close(); err != nil {
+		logger.Debug("upstream body close failed", "error", err)
+	}
+}
+
+// decodedBody returns resp's body, transparently gunzipping it when
+// upstream (or a proxy in between) sent Content-Encoding: gzip that the
+// transport didn't already decode.
+func decodedBody(resp *http.Response) (io.Reader, error) {
+	if resp.Uncompressed || !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return resp.Body, nil
+	}
+	return gzip.NewReader(resp.Body)
+}
+
+// newCompletionRequest marshals payload into an authenticated POST to the
+// Cerebras chat completions API.
+func newCompletionRequest(ctx context.Context, payload map[string]interface{}) (*http.Request, error) {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", completionsURL(), bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+os.Getenv("CEREBRAS_API_KEY"))
+	if id := requestIDFrom(ctx); id != "" {
+		httpReq.Header.Set("X-Request-ID", id)
+	}
+	return httpReq, nil
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"testing"
+	"time"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestCompletionsPath(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "path", Message: "hi"})
+	if got := upstream.LastRequest().Path; got != "/v1/chat/completions" {
+		t.Errorf("default path = %q", got)
+	}
+
+	t.Setenv("CEREBRAS_COMPLETIONS_PATH", "/v2/chat/completions")
+	if resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "path", Message: "hi"}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+	}
+	if got := upstream.LastRequest().Path; got != "/v2/chat/completions" {
+		t.Errorf("overridden path = %q, want /v2/chat/completions", got)
+	}
+}
+
+func gzipped(t *testing.T, s string) string {
+	t.Helper()
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	io.WriteString(zw, s)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestGzipResponse(t *testing.T) {
+	body := `{"object": "chat.completion", "choices": [{"message": {"role": "assistant", "content": "Unzipped."}}]}`
+	srv, _ := newTestServer(t, cerebrastest.Response{
+		Headers: map[string]string{"Content-Encoding": "gzip", "Content-Type": "application/json"},
+		Body:    gzipped(t, body),
+	})
+
+	resp, data := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "gzip", Message: "hi"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, data)
+	}
+	var reply ChatReply
+	json.Unmarshal(data, &reply)
+	if reply.Reply != "Unzipped." {
+		t.Errorf("reply = %q", reply.Reply)
+	}
+}
+
+// The transport only decodes gzip it asked for itself; a body it passes
+// through still encoded must be decoded by us.
+func TestDecodedBody(t *testing.T) {
+	resp := &http.Response{
+		Header: http.Header{"Content-Encoding": {"gzip"}},
+		Body:   io.NopCloser(bytes.NewReader([]byte(gzipped(t, "payload")))),
+	}
+	r, err := decodedBody(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(r); string(got) != "payload" {
+		t.Errorf("decoded = %q", got)
+	}
+
+	resp = &http.Response{Header: http.Header{}, Body: io.NopCloser(bytes.NewReader([]byte("plain")))}
+	r, _ = decodedBody(resp)
+	if got, _ := io.ReadAll(r); string(got) != "plain" {
+		t.Errorf("identity body = %q", got)
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	_, upstream := newTestServer(t,
+		cerebrastest.Response{Body: `{"data": []}`},
+		cerebrastest.Response{Delay: time.Second},
+	)
+
+	warmup(context.Background())
+	reqs := upstream.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("warmup made %d upstream calls, want 1", len(reqs))
+	}
+	if reqs[0].Path != "/v1/models" || reqs[0].Header.Get("Authorization") != "Bearer test-key" {
+		t.Errorf("warmup call = %s with auth %q", reqs[0].Path, reqs[0].Header.Get("Authorization"))
+	}
+
+	// an upstream that doesn't answer in time only costs the timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	warmup(ctx)
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("warmup blocked for %s past its timeout", d)
+	}
+}
+
+// trackedBody records whether it was closed.
+type trackedBody struct {
+	*strings.Reader
+	closed bool
+}
+
+func (b *trackedBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestCloseBodyAfterPartialRead(t *testing.T) {
+	closeBody(nil)
+	closeBody(&http.Response{})
+
+	body := &trackedBody{Reader: strings.NewReader(strings.Repeat("x", 1000))}
+	io.CopyN(io.Discard, body, 10)
+	closeBody(&http.Response{Body: body})
+	if !body.closed || body.Len() != 0 {
+		t.Errorf("closed = %v with %d bytes left, want drained and closed", body.closed, body.Len())
+	}
+
+	// warmup never reads the models list; draining it lets the next call
+	// reuse the connection
+	newTestServer(t, cerebrastest.Response{Body: strings.Repeat("x", 50000)})
+
+	var reused []bool
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { reused = append(reused, info.Reused) },
+	})
+	for i := 0; i < 2; i++ {
+		warmup(ctx)
+	}
+	if len(reused) != 2 || !reused[1] {
+		t.Errorf("connections reused = %v, want the second call on the first's connection", reused)
+	}
+
+	// an oversized error body is cut off after MAX_UPSTREAM_BYTES and the
+	// rest drained, so the connection is reused all the same
+	newTestServer(t, cerebrastest.Response{Status: http.StatusInternalServerError, Body: strings.Repeat("x", 50000)})
+	t.Setenv("MAX_UPSTREAM_BYTES", "100")
+	reused = nil
+	msgs := []Message{{Role: "user", Content: "hi"}}
+	for i := 0; i < 2; i++ {
+		_, status, err := completeOnce(ctx, msgs, GenParams{})
+		if status != http.StatusInternalServerError || !errors.Is(err, errUpstreamTooLarge) {
+			t.Fatalf("call %d: status = %d, err = %v", i, status, err)
+		}
+	}
+	if len(reused) != 2 || !reused[1] {
+		t.Errorf("connections reused = %v after oversized errors, want the second call on the first's connection", reused)
+	}
+}
+
+func TestRetryCount(t *testing.T) {
+	srv, upstream := newTestServer(t,
+		cerebrastest.Response{Status: http.StatusServiceUnavailable, Error: "busy"},
+		cerebrastest.Response{Status: http.StatusTooManyRequests, Error: "slow down"},
+		cerebrastest.Response{Content: "Third time."},
+	)
+	t.Setenv("UPSTREAM_RETRIES", "3")
+	t.Setenv("UPSTREAM_RETRY_BACKOFF", "1ms")
+
+	resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "flaky", Message: "hi"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("X-Retry-Count"); got != "2" {
+		t.Errorf("X-Retry-Count = %q, want 2", got)
+	}
+	resp, _ = do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "flaky", Message: "again"})
+	if got := resp.Header.Get("X-Retry-Count"); got != "0" {
+		t.Errorf("first-try success: X-Retry-Count = %q, want 0", got)
+	}
+
+	// client errors aren't retried
+	upstream.Enqueue(cerebrastest.Response{Status: http.StatusBadRequest, Error: "bad"})
+	calls := len(upstream.Requests())
+	resp, _ = do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "flaky", Message: "bad"})
+	if n := len(upstream.Requests()) - calls; n != 1 {
+		t.Errorf("400 tried %d times, want once", n)
+	}
+	if got := resp.Header.Get("X-Retry-Count"); got != "0" {
+		t.Errorf("unretried failure: X-Retry-Count = %q, want 0", got)
+	}
+
+	// failures report the retries spent before giving up
+	upstream.Enqueue(cerebrastest.Response{Status: http.StatusServiceUnavailable, Error: "down"})
+	resp, _ = do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "flaky", Message: "down"})
+	if got := resp.Header.Get("X-Retry-Count"); resp.StatusCode != http.StatusInternalServerError || got != "3" {
+		t.Errorf("status = %d, X-Retry-Count = %q, want a 500 after 3 retries", resp.StatusCode, got)
+	}
+
+	// a deadline during the backoff reports the timeout
+	upstream.Enqueue(cerebrastest.Response{Status: http.StatusBadGateway, Error: "flaky"})
+	t.Setenv("UPSTREAM_RETRY_BACKOFF", "1s")
+	t.Setenv("MAX_REQUEST_DURATION", "100ms")
+	calls = len(upstream.Requests())
+	resp, body = do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "flaky", Message: "late"})
+	if resp.StatusCode != http.StatusGatewayTimeout || !bytes.Contains(body, []byte("deadline exceeded")) {
+		t.Errorf("status = %d, body %s, want a 504 for the deadline", resp.StatusCode, body)
+	}
+	if n := len(upstream.Requests()) - calls; n != 1 {
+		t.Errorf("upstream got %d calls, want the backoff cut short", n)
+	}
+}
+
+func TestEmptyMessageObject(t *testing.T) {
+	srv, upstream := newTestServer(t)
+
+	for _, body := range []string{
+		`{"choices": [{"index": 0, "message": {}}]}`,
+		`{"choices": [{"index": 0, "message": null}]}`,
+		`{"choices": [{"index": 0}]}`,
+	} {
+		upstream.Enqueue(cerebrastest.Response{Body: body})
+		resp, reply := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "hollow", Message: "hi"})
+		if resp.StatusCode == http.StatusOK || !bytes.Contains(reply, []byte("empty message")) {
+			t.Errorf("%s: status = %d, body %s, want an empty message error", body, resp.StatusCode, reply)
+		}
+	}
+	for _, m := range history(t, srv.URL, "hollow") {
+		if m.Role != "user" {
+			t.Errorf("stored %+v from an empty message", m)
+		}
+	}
+}
+
+func TestUserForwarded(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Ok.", Stream: []string{"Ok."}})
+
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "anon", Message: "hi"})
+	if user, ok := upstream.LastRequest().Body["user"]; ok {
+		t.Errorf("user sent without one supplied: %v", user)
+	}
+
+	for _, path := range []string{"/api/chat", "/api/chat/stream"} {
+		do(t, "POST", srv.URL+path, ChatRequest{SessionID: "known", Message: "hi", GenParams: GenParams{User: "user-42"}})
+		if got := upstream.LastRequest().Body["user"]; got != "user-42" {
+			t.Errorf("%s: user = %v, want user-42", path, got)
+		}
+	}
+	do(t, "POST", srv.URL+"/api/chat/stateless", StatelessRequest{
+		Messages:  []Message{{Role: "user", Content: "hi"}},
+		GenParams: GenParams{User: "user-42"},
+	})
+	if got := upstream.LastRequest().Body["user"]; got != "user-42" {
+		t.Errorf("stateless: user = %v, want user-42", got)
+	}
+}
+
+func TestValidateModelEcho(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Ok.", Model: "llama-fallback"})
+	logs := captureLogs(t)
+
+	chat := func() ChatReply {
+		_, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "echo", Message: "hi"})
+		var reply ChatReply
+		json.Unmarshal(body, &reply)
+		return reply
+	}
+
+	if reply := chat(); reply.Model != "" {
+		t.Errorf("model surfaced without VALIDATE_MODEL_ECHO: %q", reply.Model)
+	}
+	if recs := logs.records(t, "upstream answered with a different model"); len(recs) != 0 {
+		t.Errorf("warned without VALIDATE_MODEL_ECHO: %v", recs)
+	}
+
+	t.Setenv("VALIDATE_MODEL_ECHO", "true")
+	if reply := chat(); reply.Model != "llama-fallback" {
+		t.Errorf("model = %q, want the one upstream answered with", reply.Model)
+	}
+	recs := logs.records(t, "upstream answered with a different model")
+	if len(recs) != 1 || recs[0]["requested"] != "gpt-oss-120b" || recs[0]["actual"] != "llama-fallback" {
+		t.Errorf("mismatch warnings = %v", recs)
+	}
+
+	// a matching echo is quiet
+	upstream.Enqueue(cerebrastest.Response{Content: "Ok."})
+	if reply := chat(); reply.Model != "gpt-oss-120b" {
+		t.Errorf("model = %q, want the echoed request model", reply.Model)
+	}
+	if recs := logs.records(t, "upstream answered with a different model"); len(recs) != 1 {
+		t.Errorf("got %d mismatch warnings after a matching echo, want still 1", len(recs))
+	}
+}
+
+func TestMaxUpstreamBytes(t *testing.T) {
+	big := strings.Repeat("x", 5000)
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: big})
+	t.Setenv("MAX_UPSTREAM_BYTES", "4096")
+
+	resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "big", Message: "hi"})
+	if resp.StatusCode != http.StatusBadGateway || !bytes.Contains(body, []byte("upstream response too large")) {
+		t.Errorf("status = %d, body %s, want a 502", resp.StatusCode, body)
+	}
+	for _, m := range history(t, srv.URL, "big") {
+		if m.Role != "user" {
+			t.Errorf("stored %+v from an oversized response", m)
+		}
+	}
+
+	// the cap applies to the decompressed size
+	zipped := gzipped(t, `{"choices": [{"message": {"role": "assistant", "content": "`+big+`"}}]}`)
+	if len(zipped) >= 4096 {
+		t.Fatalf("compressed body is %d bytes, want it under the cap", len(zipped))
+	}
+	upstream.Enqueue(cerebrastest.Response{Headers: map[string]string{"Content-Encoding": "gzip"}, Body: zipped})
+	if resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "big", Message: "hi"}); resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("gzipped: status = %d, body %s, want a 502", resp.StatusCode, body)
+	}
+
+	upstream.Enqueue(cerebrastest.Response{Content: "Small."})
+	if resp, _ := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "big", Message: "hi"}); resp.StatusCode != http.StatusOK {
+		t.Errorf("small response: status = %d", resp.StatusCode)
+	}
+}
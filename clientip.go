@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// trustedProxies are the peers whose X-Forwarded-For we believe.
+var trustedProxies = parsePrefixes("TRUSTED_PROXIES")
+
+// clientIP returns the real client IP. X-Forwarded-For is only consulted
+// when the direct peer is a trusted proxy; it is then walked from the
+// right, skipping further trusted hops, so a client can't spoof its
+// address by prepending entries.
+func clientIP(r *http.Request) string {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		peer = host
+	}
+	if !isTrustedProxy(peer) {
+		return peer
+	}
+
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(h, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	if len(hops) == 0 {
+		return peer
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		if _, err := netip.ParseAddr(hops[i]); err != nil {
+			// garbage in the chain; trust nothing further left of it
+			return peer
+		}
+		if !isTrustedProxy(hops[i]) {
+			return hops[i]
+		}
+	}
+	// every hop is one of ours: the leftmost is the closest we get
+	return hops[0]
+}
+
+func isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	return containsIP(trustedProxies, addr.Unmap())
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8")
+	setVar(t, &trustedProxies, parsePrefixes("TRUSTED_PROXIES"))
+
+	for _, tc := range []struct {
+		name, peer, xff, want string
+	}{
+		{"untrusted peer ignores XFF", "203.0.113.7:5000", "198.51.100.1", "203.0.113.7"},
+		{"trusted peer uses XFF", "10.0.0.2:5000", "198.51.100.1", "198.51.100.1"},
+		{"spoofed leftmost entry is skipped", "10.0.0.2:5000", "1.2.3.4, 198.51.100.1", "198.51.100.1"},
+		{"trusted hops are walked past", "10.0.0.2:5000", "198.51.100.1, 10.0.0.9", "198.51.100.1"},
+		{"all hops trusted", "10.0.0.2:5000", "10.0.0.8, 10.0.0.9", "10.0.0.8"},
+		{"garbage stops the walk", "10.0.0.2:5000", "198.51.100.1, junk", "10.0.0.2"},
+		{"trusted peer without XFF", "10.0.0.2:5000", "", "10.0.0.2"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tc.peer
+		if tc.xff != "" {
+			r.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		if got := clientIP(r); got != tc.want {
+			t.Errorf("%s: clientIP = %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
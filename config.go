@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envList reads a comma-separated list from the environment, falling back
+// to def when unset. Empty entries are dropped.
+func envList(key string, def []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// envString reads a string from the environment, falling back to def when
+// unset.
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envBool reads a boolean flag ("true", "1", ...) from the environment,
+// falling back to def when unset or invalid.
+func envBool(key string, def bool) bool {
+	b, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return b
+}
+
+// envInt reads an integer from the environment, falling back to def when
+// unset or invalid.
+func envInt(key string, def int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// envFloat reads a float from the environment, falling back to def when
+// unset or invalid.
+func envFloat(key string, def float64) float64 {
+	f, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return def
+	}
+	return f
+}
+
+// envFloatList reads a comma-separated list of numbers from the
+// environment, falling back to def when unset or when any entry is invalid.
+func envFloatList(key string, def []float64) []float64 {
+	items := envList(key, nil)
+	if len(items) == 0 {
+		return def
+	}
+	out := make([]float64, 0, len(items))
+	for _, item := range items {
+		f, err := strconv.ParseFloat(item, 64)
+		if err != nil {
+			return def
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+// envDuration reads a Go duration (e.g. "15s") from the environment,
+// falling back to def when unset or invalid.
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sessionCookieName is the cookie SESSION_COOKIE keeps the session ID in.
+func sessionCookieName() string {
+	return envString("SESSION_COOKIE_NAME", "cerebraschat_session")
+}
+
+// withCookieSession resolves the session ID for r. An explicit id always
+// wins. Otherwise, with SESSION_COOKIE on, the ID comes from an HttpOnly
+// cookie, minted and set on the response the first time, so browser
+// clients never have to track one; without it the shared default applies
+// as before.
+func withCookieSession(w http.ResponseWriter, r *http.Request, id string) string {
+	if id != "" || !envBool("SESSION_COOKIE", false) {
+		return id
+	}
+	if c, err := r.Cookie(sessionCookieName()); err == nil && validSessionID(c.Value) {
+		return c.Value
+	}
+
+	id = newRequestID() // random hex is a valid session ID
+	sameSite := http.SameSiteLaxMode
+	secure := envBool("SESSION_COOKIE_SECURE", false)
+	switch strings.ToLower(envString("SESSION_COOKIE_SAMESITE", "lax")) {
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		// browsers drop SameSite=None cookies that aren't Secure
+		sameSite, secure = http.SameSiteNoneMode, true
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName(),
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(envDuration("SESSION_TTL", 24*time.Hour).Seconds()),
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: sameSite,
+	})
+	return id
+}
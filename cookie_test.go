@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestSessionCookie(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+	t.Setenv("SESSION_COOKIE", "true")
+	chat := func(cookie *http.Cookie) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest("POST", srv.URL+"/api/chat", bytes.NewBufferString(`{"message": "hi"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		resp, body := send(t, req)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+		}
+		return resp
+	}
+
+	cookies := chat(nil).Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "cerebraschat_session" || cookies[0].Value == "" {
+		t.Fatalf("first request set cookies %v, want one session cookie", cookies)
+	}
+	c := cookies[0]
+	if !c.HttpOnly || c.Secure || c.SameSite != http.SameSiteLaxMode {
+		t.Errorf("cookie = %+v, want HttpOnly and SameSite=Lax", c)
+	}
+
+	// the next request reuses the session instead of minting another
+	if again := chat(c).Cookies(); len(again) != 0 {
+		t.Errorf("second request set cookies %v", again)
+	}
+	if got := len(sentMessages(t, upstream.LastRequest())); got != 4 {
+		t.Errorf("second turn sent %d messages, want system plus the first exchange and the new message", got)
+	}
+	if got := len(history(t, srv.URL, c.Value)); got != 4 {
+		t.Errorf("history under the cookie's ID has %d messages, want 4", got)
+	}
+
+	// the cookie names the session to delete too
+	req, _ := http.NewRequest("DELETE", srv.URL+"/api/session", nil)
+	req.AddCookie(c)
+	if resp, _ := send(t, req); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("delete by cookie: status = %d, want 204", resp.StatusCode)
+	}
+	if _, ok, _ := findSession(c.Value); ok {
+		t.Error("session named by the cookie survived the delete")
+	}
+
+	// an explicit session_id still wins
+	resp, _ := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "explicit", Message: "hi"})
+	if got := resp.Cookies(); len(got) != 0 {
+		t.Errorf("explicit session set cookies %v", got)
+	}
+
+	t.Setenv("SESSION_COOKIE_SAMESITE", "none")
+	if c := chat(nil).Cookies()[0]; c.SameSite != http.SameSiteNoneMode || !c.Secure {
+		t.Errorf("SameSite=None cookie = %+v, want it Secure", c)
+	}
+}
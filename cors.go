@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+)
+
+var allowedOrigins = envList("CORS_ALLOWED_ORIGINS", []string{
+	"https://dibinxavier.github.io",
+	"http://localhost:5500",
+	"https://bodha-zeta.vercel.app",
+})
+
+// withCORS applies CORS headers to every route and answers preflight
+// requests itself, so individual handlers never see OPTIONS.
+func withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// ✅ CORS FIRST — ALWAYS
+		enableCORS(w, r)
+
+		// ✅ Handle preflight
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func enableCORS(w http.ResponseWriter, r *http.Request) {
+	if origin := requestOrigin(r); isAllowedOrigin(origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+		if envBool("SESSION_COOKIE", false) {
+			// lets the browser send the session cookie cross-origin
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+
+	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID, X-Signature, X-Priority-Token")
+	w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
+}
+
+// exposedHeaders are the response headers browser code may read, beyond
+// the CORS-safelisted ones: IDs for bug reports and what a frontend needs
+// to back off.
+const exposedHeaders = "X-Request-ID, Retry-After, X-Retry-Count, " +
+	"X-RateLimit-Limit-Requests, X-RateLimit-Remaining-Requests, X-RateLimit-Reset-Requests, " +
+	"X-RateLimit-Limit-Tokens, X-RateLimit-Remaining-Tokens, X-RateLimit-Reset-Tokens"
+
+// requestOrigin returns the Origin header, or for embedded webviews that
+// omit it, the scheme and host of the Referer.
+func requestOrigin(r *http.Request) string {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		return origin
+	}
+
+	ref, err := url.Parse(r.Header.Get("Referer"))
+	if err != nil || ref.Scheme == "" || ref.Host == "" {
+		return ""
+	}
+	return ref.Scheme + "://" + ref.Host
+}
+
+// isAllowedOrigin checks origin against the allowlist. With
+// REQUIRE_HTTPS_ORIGIN on, plain-http entries only count for localhost.
+func isAllowedOrigin(origin string) bool {
+	if envBool("REQUIRE_HTTPS_ORIGIN", false) && !secureOrigin(origin) {
+		return false
+	}
+	for _, allowed := range allowedOrigins {
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// secureOrigin reports whether origin is https, or http on a loopback
+// host used for local development.
+func secureOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+	return false
+}
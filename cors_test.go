@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPreflightOnEveryRoute(t *testing.T) {
+	srv, upstream := newTestServer(t)
+	origin := "https://dibinxavier.github.io"
+
+	var first http.Header
+	for _, path := range []string{
+		"/api/chat",
+		"/api/chat/stream",
+		"/api/history",
+		"/api/reset",
+		"/api/message/m1",
+		"/admin/sessions",
+	} {
+		req, _ := http.NewRequest("OPTIONS", srv.URL+path, nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		resp, _ := send(t, req)
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("OPTIONS %s: status = %d, want 200", path, resp.StatusCode)
+		}
+		if got := resp.Header.Get("Access-Control-Allow-Origin"); got != origin {
+			t.Errorf("OPTIONS %s: Allow-Origin = %q", path, got)
+		}
+		if first == nil {
+			first = resp.Header
+			continue
+		}
+		for _, h := range []string{"Access-Control-Allow-Methods", "Access-Control-Allow-Headers", "Access-Control-Expose-Headers"} {
+			if got, want := resp.Header.Get(h), first.Get(h); got != want || got == "" {
+				t.Errorf("OPTIONS %s: %s = %q, want %q as on every route", path, h, got, want)
+			}
+		}
+	}
+	if n := len(upstream.Requests()); n != 0 {
+		t.Errorf("preflights reached upstream %d times", n)
+	}
+}
+
+func TestCORSRefererFallback(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	for referer, want := range map[string]string{
+		"https://dibinxavier.github.io/bodha/index.html": "https://dibinxavier.github.io",
+		"https://evil.example/dibinxavier.github.io":     "",
+	} {
+		req, _ := http.NewRequest("GET", srv.URL+"/health", nil)
+		req.Header.Set("Referer", referer)
+		resp, _ := send(t, req)
+		if got := resp.Header.Get("Access-Control-Allow-Origin"); got != want {
+			t.Errorf("Referer %s: Allow-Origin = %q, want %q", referer, got, want)
+		}
+	}
+}
+
+func TestRequireHTTPSOrigin(t *testing.T) {
+	srv, _ := newTestServer(t)
+	setVar(t, &allowedOrigins, []string{
+		"https://chat.example.com",
+		"http://chat.example.com",
+		"http://localhost:5173",
+	})
+
+	allowOrigin := func(origin string) string {
+		req, _ := http.NewRequest("GET", srv.URL+"/health", nil)
+		req.Header.Set("Origin", origin)
+		resp, _ := send(t, req)
+		return resp.Header.Get("Access-Control-Allow-Origin")
+	}
+
+	if got := allowOrigin("http://chat.example.com"); got != "http://chat.example.com" {
+		t.Errorf("by default the listed http origin is allowed, got %q", got)
+	}
+
+	t.Setenv("REQUIRE_HTTPS_ORIGIN", "true")
+	for origin, allowed := range map[string]bool{
+		"https://chat.example.com": true,
+		"http://chat.example.com":  false,
+		"http://localhost:5173":    true,
+	} {
+		if got := allowOrigin(origin) == origin; got != allowed {
+			t.Errorf("%s: allowed = %v, want %v", origin, got, allowed)
+		}
+	}
+}
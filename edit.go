@@ -0,0 +1,134 @@
+package main
+
+import "net/http"
+
+// isReply reports whether m was produced by the model rather than the
+// user or the server.
+func isReply(m Message) bool {
+	return m.Role != "user" && m.Role != "system"
+}
+
+// handleRegenerate drops the last assistant reply and asks the model again
+// for the same user message, replacing the old reply with the new one.
+func handleRegenerate(w http.ResponseWriter, r *http.Request) {
+	var req ChatRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeErrorStatus(w, r, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	if err := validateTurnOptions(&req); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	req.SessionID = withCookieSession(w, r, req.SessionID)
+
+	sess, ok, err := findSession(req.SessionID)
+	if err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	if !ok {
+		writeErrorStatus(w, r, http.StatusNotFound, "session not found")
+		return
+	}
+	if !sess.lockTurn() {
+		writeErrorStatus(w, r, http.StatusConflict, "request in progress")
+		return
+	}
+	defer sess.unlockTurn()
+	defer saveSession(req.SessionID, sess)
+	if err := sess.checkTurn(&req); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	if last, ok := sess.history.last(); !ok || !isReply(last) {
+		writeErrorStatus(w, r, http.StatusBadRequest, "last message is not an assistant reply")
+		return
+	}
+	old, _ := sess.history.pop()
+
+	runTurn(w, r, sess, &req, "regenerate")
+
+	// if the new completion failed, put the old reply back so the session
+	// isn't left ending on a bare user message
+	if last, ok := sess.history.last(); ok && !isReply(last) {
+		sess.append(old)
+	}
+}
+
+// handleEditLast replaces the content of the most recent user message,
+// drops the reply to it, and re-runs the completion.
+func handleEditLast(w http.ResponseWriter, r *http.Request) {
+	var req ChatRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeErrorStatus(w, r, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	req.Message = normalizeMessage(req.Message)
+	if req.Message == "" {
+		writeInvalid(w, r, invalid("message", "is required"))
+		return
+	}
+	if err := validateTurnOptions(&req); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	req.SessionID = withCookieSession(w, r, req.SessionID)
+
+	sess, ok, err := findSession(req.SessionID)
+	if err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	if !ok {
+		writeErrorStatus(w, r, http.StatusNotFound, "session not found")
+		return
+	}
+	if !sess.lockTurn() {
+		writeErrorStatus(w, r, http.StatusConflict, "request in progress")
+		return
+	}
+	defer sess.unlockTurn()
+	defer saveSession(req.SessionID, sess)
+	if err := sess.checkTurn(&req); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	if blockInjection(r.Context(), req.Message) {
+		writeReply(w, r, ChatReply{Reply: sess.turnDismissal(&req), Blocked: true})
+		return
+	}
+
+	// history ends with the user message, optionally followed by its reply
+	var removed []Message
+	if last, ok := sess.history.last(); ok && isReply(last) {
+		sess.history.pop()
+		removed = append(removed, last)
+	}
+	last, ok := sess.history.last()
+	if !ok || last.Role != "user" {
+		for i := len(removed) - 1; i >= 0; i-- {
+			sess.history.push(removed[i])
+		}
+		writeErrorStatus(w, r, http.StatusBadRequest, "no user message to edit")
+		return
+	}
+	sess.history.pop()
+	removed = append(removed, last)
+
+	edited := last
+	edited.Content = req.Message
+	sess.history.push(edited)
+
+	runTurn(w, r, sess, &req, "edit-last")
+
+	// on failure restore the conversation exactly as it was
+	if last, ok := sess.history.last(); ok && !isReply(last) {
+		sess.history.pop()
+		for i := len(removed) - 1; i >= 0; i-- {
+			sess.history.push(removed[i])
+		}
+	}
+}
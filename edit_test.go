@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func history(t *testing.T, base, id string) []Message {
+	t.Helper()
+	resp, body := do(t, "GET", base+"/api/history?session_id="+id, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("history: status = %d, body %s", resp.StatusCode, body)
+	}
+	var h HistoryReply
+	json.Unmarshal(body, &h)
+	return h.Messages
+}
+
+func TestRegenerate(t *testing.T) {
+	srv, upstream := newTestServer(t,
+		cerebrastest.Response{Content: "First take."},
+		cerebrastest.Response{Content: "Second take."},
+	)
+
+	if resp, _ := do(t, "POST", srv.URL+"/api/regenerate", ChatRequest{SessionID: "regen"}); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown session: status = %d, want 404", resp.StatusCode)
+	}
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "regen", Message: "hi"})
+
+	resp, body := do(t, "POST", srv.URL+"/api/regenerate", ChatRequest{SessionID: "regen"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+	}
+	var reply ChatReply
+	json.Unmarshal(body, &reply)
+	if reply.Reply != "Second take." {
+		t.Errorf("reply = %q", reply.Reply)
+	}
+	// the regeneration is asked for the same user message, without the old reply
+	if got := fmt.Sprint(contents(sentMessages(t, upstream.LastRequest())[1:])); got != "[hi]" {
+		t.Errorf("regeneration sent %s, want [hi]", got)
+	}
+	if got := fmt.Sprint(contents(history(t, srv.URL, "regen"))); got != "[hi Second take.]" {
+		t.Errorf("history = %s, want the reply replaced", got)
+	}
+
+	// only a trailing assistant reply can be regenerated
+	store.Save("bare", &session{history: newRing(10), persona: personas["bodha"], personaName: "bodha"})
+	sess, _, _ := store.Get("bare")
+	sess.append(Message{Role: "user", Content: "unanswered"})
+	if resp, _ := do(t, "POST", srv.URL+"/api/regenerate", ChatRequest{SessionID: "bare"}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("last message from the user: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestEditLast(t *testing.T) {
+	srv, upstream := newTestServer(t,
+		cerebrastest.Response{Content: "Paris."},
+		cerebrastest.Response{Content: "Rome."},
+	)
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "edit", Message: "capital of France?"})
+
+	resp, body := do(t, "POST", srv.URL+"/api/edit-last", ChatRequest{SessionID: "edit", Message: "capital of Italy?"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+	}
+	if got := fmt.Sprint(contents(sentMessages(t, upstream.LastRequest())[1:])); got != "[capital of Italy?]" {
+		t.Errorf("edit sent %s, want only the edited message", got)
+	}
+	if got := fmt.Sprint(contents(history(t, srv.URL, "edit"))); got != "[capital of Italy? Rome.]" {
+		t.Errorf("history = %s", got)
+	}
+
+	if resp, _ := do(t, "POST", srv.URL+"/api/edit-last", ChatRequest{SessionID: "edit"}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("empty edit: status = %d, want 400", resp.StatusCode)
+	}
+	do(t, "POST", srv.URL+"/api/reset", CancelRequest{SessionID: "edit"})
+	if resp, _ := do(t, "POST", srv.URL+"/api/edit-last", ChatRequest{SessionID: "edit", Message: "anything"}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("nothing to edit: status = %d, want 400", resp.StatusCode)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+type ForkRequest struct {
+	SessionID string `json:"session_id"`
+	// NewSessionID names the fork; a random ID is chosen when empty.
+	NewSessionID string `json:"new_session_id,omitempty"`
+}
+
+type ForkReply struct {
+	SessionID string `json:"session_id"`
+}
+
+// handleFork copies a session, history and all, under a new ID so the
+// conversation can branch. The copy shares nothing with the original:
+// later turns on either leave the other untouched. Message IDs carry over,
+// so a message can be matched up across the two branches.
+func handleFork(w http.ResponseWriter, r *http.Request) {
+	var req ForkRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeErrorStatus(w, r, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	req.SessionID = withCookieSession(w, r, req.SessionID)
+	newID := req.NewSessionID
+	if newID == "" {
+		newID = newRequestID() // random hex is a valid session ID
+	} else if !validSessionID(newID) {
+		writeInvalid(w, r, invalid("new_session_id", "use up to %d letters, digits or dashes", envInt("MAX_SESSION_ID_LEN", 64)))
+		return
+	}
+
+	src, ok, err := findSession(req.SessionID)
+	if err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	if !ok {
+		writeErrorStatus(w, r, http.StatusNotFound, "session not found")
+		return
+	}
+
+	// snapshot first: src.mu can be held for a whole turn, and waiting on
+	// it while holding sessionsMu would stall every other chat request
+	src.mu.RLock()
+	fork := src.fork()
+	src.mu.RUnlock()
+
+	// held so a first request for newID can't create it meanwhile
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	_, exists, err := store.Get(newID)
+	if err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	if exists {
+		writeErrorStatus(w, r, http.StatusConflict, "session already exists")
+		return
+	}
+
+	if err := store.Save(newID, fork); err != nil {
+		writeErrorStatus(w, r, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeJSON(w, r, http.StatusCreated, ForkReply{SessionID: newID})
+}
+
+// fork returns a deep copy of s. Callers must hold s.mu.
+func (s *session) fork() *session {
+	history := newRing(len(s.history.buf))
+	for _, m := range s.history.slice() {
+		history.push(m)
+	}
+	return &session{
+		personaName: s.personaName,
+		persona:     s.persona,
+		system:      s.system,
+		model:       s.model,
+		history:     history,
+		userTurns:   s.userTurns,
+		lastID:      s.lastID,
+		lastActive:  time.Now(),
+		title:       s.title,
+		titleTurns:  s.titleTurns,
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestFork(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "trunk", Message: "shared"})
+
+	resp, body := do(t, "POST", srv.URL+"/api/session/fork", ForkRequest{SessionID: "trunk", NewSessionID: "branch"})
+	var fork ForkReply
+	json.Unmarshal(body, &fork)
+	if resp.StatusCode != http.StatusCreated || fork.SessionID != "branch" {
+		t.Fatalf("fork: status = %d, reply %s", resp.StatusCode, body)
+	}
+
+	// both diverge; neither sees the other's turn
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "trunk", Message: "left"})
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "branch", Message: "right"})
+	if sent := contents(sentMessages(t, upstream.LastRequest())[1:]); strings.Join(sent, "|") != "shared|Ok.|right" {
+		t.Errorf("branch payload = %q", sent)
+	}
+	trunk, branch := history(t, srv.URL, "trunk"), history(t, srv.URL, "branch")
+	if got := strings.Join(contents(trunk), "|"); got != "shared|Ok.|left|Ok." {
+		t.Errorf("trunk history = %q", got)
+	}
+	if got := strings.Join(contents(branch), "|"); got != "shared|Ok.|right|Ok." {
+		t.Errorf("branch history = %q", got)
+	}
+	// shared messages keep their IDs; new ones continue the sequence
+	if trunk[0].ID != branch[0].ID || trunk[1].ID != branch[1].ID || branch[2].ID != trunk[2].ID {
+		t.Errorf("IDs: trunk %+v, branch %+v", trunk, branch)
+	}
+
+	resp, body = do(t, "POST", srv.URL+"/api/session/fork", ForkRequest{SessionID: "trunk"})
+	json.Unmarshal(body, &fork)
+	if resp.StatusCode != http.StatusCreated || fork.SessionID == "" || fork.SessionID == "branch" {
+		t.Errorf("fork without a name: status = %d, reply %s", resp.StatusCode, body)
+	}
+	for name, tc := range map[string]struct {
+		req    ForkRequest
+		status int
+	}{
+		"existing name":  {ForkRequest{SessionID: "trunk", NewSessionID: "branch"}, http.StatusConflict},
+		"missing source": {ForkRequest{SessionID: "nowhere", NewSessionID: "other"}, http.StatusNotFound},
+		"invalid name":   {ForkRequest{SessionID: "trunk", NewSessionID: "no good"}, http.StatusBadRequest},
+	} {
+		if resp, _ := do(t, "POST", srv.URL+"/api/session/fork", tc.req); resp.StatusCode != tc.status {
+			t.Errorf("%s: status = %d, want %d", name, resp.StatusCode, tc.status)
+		}
+	}
+}
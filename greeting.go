@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const greetingInstruction = "Open the conversation with a single short greeting line, in character. Do not answer anything yet."
+
+// greetingCache holds generated greetings by persona name, filled at
+// startup by prewarmGreetings.
+var (
+	greetingCache   = map[string]string{}
+	greetingCacheMu sync.RWMutex
+)
+
+// handleGreeting returns an opening line for ?persona= (default persona if
+// unset): a pre-generated greeting, the persona's configured one, or a
+// one-shot generated one. Nothing is stored, so it never counts as a
+// conversation turn.
+func handleGreeting(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("persona")
+	if name == "" {
+		name = defaultPersona
+	}
+	p, ok := personas[name]
+	if !ok {
+		writeErrorStatus(w, r, http.StatusBadRequest, fmt.Sprintf("unknown persona %q", name))
+		return
+	}
+	msgs, err := greetingMessages(p)
+	if err != nil {
+		writeError(w, r, "system prompt template error: "+err.Error())
+		return
+	}
+	greetingCacheMu.RLock()
+	cached, ok := greetingCache[name]
+	greetingCacheMu.RUnlock()
+	if ok {
+		writeJSON(w, r, http.StatusOK, ChatReply{Reply: cached, SystemHash: systemHash(msgs)})
+		return
+	}
+	if p.Greeting != "" {
+		writeJSON(w, r, http.StatusOK, ChatReply{Reply: p.Greeting, SystemHash: systemHash(msgs)})
+		return
+	}
+
+	apiRes, err := complete(r.Context(), msgs, defaultParams.merge(p.Params))
+	if err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, ChatReply{Reply: firstLine(apiRes.Choices[0].Message.Content), SystemHash: systemHash(msgs)})
+}
+
+// greetingMessages is the one-shot conversation that asks p for a greeting.
+func greetingMessages(p Persona) ([]Message, error) {
+	system, err := renderSystemPrompt(p.SystemPrompt, nil)
+	if err != nil {
+		return nil, err
+	}
+	return []Message{
+		{Role: "system", Content: system},
+		{Role: "user", Content: greetingInstruction},
+	}, nil
+}
+
+// prewarmGreetings generates and caches a greeting for each persona named
+// in PREWARM_GREETINGS ("*" for all), so /api/greeting answers them
+// without a round trip. A prewarmed greeting replaces the persona's
+// configured one. Failures are logged and leave the persona on its
+// configured greeting, or to be generated on demand.
+func prewarmGreetings(ctx context.Context) {
+	names := envList("PREWARM_GREETINGS", nil)
+	if len(names) == 1 && names[0] == "*" {
+		names = names[:0]
+		for name := range personas {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		p, ok := personas[name]
+		if !ok {
+			logger.Warn("greeting prewarm: unknown persona", "persona", name)
+			continue
+		}
+		msgs, err := greetingMessages(p)
+		if err != nil {
+			logger.Warn("greeting prewarm failed", "persona", name, "error", err)
+			continue
+		}
+		start := time.Now()
+		apiRes, err := complete(ctx, msgs, defaultParams.merge(p.Params))
+		if err != nil {
+			logger.Warn("greeting prewarm failed", "persona", name, "error", err)
+			continue
+		}
+		greetingCacheMu.Lock()
+		greetingCache[name] = firstLine(apiRes.Choices[0].Message.Content)
+		greetingCacheMu.Unlock()
+		logger.Info("greeting prewarmed", "persona", name, "latency_ms", time.Since(start).Milliseconds())
+	}
+}
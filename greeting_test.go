@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestGreeting(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Well, look who showed up.\nSecond line."})
+	setVar(t, &greetingCache, map[string]string{})
+	withPersona(t, "quiet", Persona{SystemPrompt: "Be quiet."})
+
+	greeting := func(persona string) (int, ChatReply) {
+		resp, body := do(t, "GET", srv.URL+"/api/greeting?persona="+persona, nil)
+		var reply ChatReply
+		json.Unmarshal(body, &reply)
+		return resp.StatusCode, reply
+	}
+
+	if status, reply := greeting("bodha"); status != http.StatusOK || reply.Reply != personas["bodha"].Greeting || reply.SystemHash == "" {
+		t.Errorf("configured greeting: status = %d, reply = %+v", status, reply)
+	}
+	if n := len(upstream.Requests()); n != 0 {
+		t.Errorf("configured greeting called upstream %d times", n)
+	}
+
+	if _, reply := greeting("quiet"); reply.Reply != "Well, look who showed up." {
+		t.Errorf("generated greeting = %q, want its first line", reply.Reply)
+	}
+	if sent := sentMessages(t, upstream.LastRequest()); sent[len(sent)-1].Content != greetingInstruction {
+		t.Errorf("greeting payload = %+v", sent)
+	}
+
+	if status, _ := greeting("nobody"); status != http.StatusBadRequest {
+		t.Errorf("unknown persona: status = %d, want 400", status)
+	}
+	if ids, _ := store.List(); len(ids) != 0 {
+		t.Errorf("greetings created sessions %v", ids)
+	}
+}
+
+func TestPrewarmGreetings(t *testing.T) {
+	srv, upstream := newTestServer(t,
+		cerebrastest.Response{Content: "Fresh roast, served hot.\nMore."},
+		cerebrastest.Response{Status: http.StatusInternalServerError, Error: "down"},
+	)
+	setVar(t, &greetingCache, map[string]string{})
+	withPersona(t, "quiet", Persona{SystemPrompt: "Be quiet."})
+	t.Setenv("PREWARM_GREETINGS", "bodha, quiet, nobody")
+
+	prewarmGreetings(context.Background())
+	if n := len(upstream.Requests()); n != 2 {
+		t.Errorf("prewarm made %d upstream calls, want one per known persona", n)
+	}
+	if got := greetingCache["bodha"]; got != "Fresh roast, served hot." {
+		t.Errorf("cached bodha greeting = %q", got)
+	}
+	if _, ok := greetingCache["quiet"]; ok {
+		t.Error("failed prewarm cached a greeting")
+	}
+
+	// the prewarmed greeting replaces the configured one, with no new call
+	_, body := do(t, "GET", srv.URL+"/api/greeting?persona=bodha", nil)
+	var reply ChatReply
+	json.Unmarshal(body, &reply)
+	if reply.Reply != "Fresh roast, served hot." {
+		t.Errorf("greeting = %q, want the prewarmed one", reply.Reply)
+	}
+	if n := len(upstream.Requests()); n != 2 {
+		t.Error("serving a prewarmed greeting called upstream")
+	}
+}
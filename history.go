@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+type HistoryReply struct {
+	SessionID string    `json:"session_id"`
+	Messages  []Message `json:"messages"`
+}
+
+// handleHistory returns a session's stored turns, with their IDs, oldest
+// first. System messages are filtered out; admins can ask for them,
+// pinned prompt included, with ?include_system=true. Truncated replies end
+// with TRUNCATION_NOTE so an incomplete answer is obvious on reload.
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	includeSystem, _ := strconv.ParseBool(r.URL.Query().Get("include_system"))
+	if includeSystem && !isAdmin(r) {
+		writeErrorStatus(w, r, http.StatusUnauthorized, "admin token required")
+		return
+	}
+
+	id := sessionKey(withCookieSession(w, r, r.URL.Query().Get("session_id")))
+	sess, ok, err := findSession(id)
+	if err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	if !ok {
+		writeErrorStatus(w, r, http.StatusNotFound, "session not found")
+		return
+	}
+
+	sess.mu.RLock()
+	msgs := sess.history.slice()
+	if includeSystem && sess.system != "" {
+		msgs = append([]Message{{Role: "system", Content: sess.system}}, msgs...)
+	}
+	sess.mu.RUnlock()
+	if !includeSystem {
+		msgs = withoutSystem(msgs)
+	}
+	if note := envString("TRUNCATION_NOTE", " …"); note != "" {
+		for i := range msgs {
+			if msgs[i].Truncated {
+				msgs[i].Content += note
+			}
+		}
+	}
+
+	writeJSON(w, r, http.StatusOK, HistoryReply{SessionID: id, Messages: msgs})
+}
+
+// withoutSystem drops every system-role message, so persona internals
+// never reach client-facing output.
+func withoutSystem(msgs []Message) []Message {
+	out := make([]Message, 0, len(msgs))
+	for _, m := range msgs {
+		if m.Role != "system" {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// handleDeleteMessage removes one message from a session's history by ID.
+// With ?pair=true the other half of its turn (the reply to a user message,
+// or the user message a reply answers) goes too. The ring is rebuilt in
+// order so the remaining context stays coherent.
+func handleDeleteMessage(w http.ResponseWriter, r *http.Request) {
+	sessionID := withCookieSession(w, r, r.URL.Query().Get("session_id"))
+	sess, ok, err := findSession(sessionID)
+	if err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	if !ok {
+		writeErrorStatus(w, r, http.StatusNotFound, "session not found")
+		return
+	}
+	pair, _ := strconv.ParseBool(r.URL.Query().Get("pair"))
+	id := r.PathValue("id")
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	defer saveSession(sessionID, sess)
+
+	msgs := sess.history.slice()
+	at := -1
+	for i, m := range msgs {
+		if m.ID == id {
+			at = i
+			break
+		}
+	}
+	if at < 0 {
+		writeErrorStatus(w, r, http.StatusNotFound, "message not found")
+		return
+	}
+
+	from, to := at, at+1
+	if pair {
+		if msgs[at].Role == "user" && to < len(msgs) && isReply(msgs[to]) {
+			to++
+		} else if isReply(msgs[at]) && from > 0 && msgs[from-1].Role == "user" {
+			from--
+		}
+	}
+
+	sess.history.clear()
+	for _, m := range append(msgs[:from:from], msgs[to:]...) {
+		sess.history.push(m)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleReset clears a session's conversation back to its system prompt.
+// A stream in flight on the session is cancelled first, and the reset
+// waits for the session lock, so a turn that was already running finishes
+// its append before the history is cleared rather than after.
+func handleReset(w http.ResponseWriter, r *http.Request) {
+	var req CancelRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeErrorStatus(w, r, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	req.SessionID = withCookieSession(w, r, req.SessionID)
+	sess, ok, err := findSession(req.SessionID)
+	if err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	if !ok {
+		writeErrorStatus(w, r, http.StatusNotFound, "session not found")
+		return
+	}
+
+	cancelStream(req.SessionID, errSessionReset)
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.reset()
+	saveSession(req.SessionID, sess)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDeleteSession ends a session for good: a stream in flight on it
+// is cancelled, the conversation's length recorded, and the session
+// removed from the store, so its ID starts afresh if used again.
+func handleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	id := withCookieSession(w, r, r.URL.Query().Get("session_id"))
+	sess, ok, err := findSession(id)
+	if err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	if !ok {
+		writeErrorStatus(w, r, http.StatusNotFound, "session not found")
+		return
+	}
+
+	cancelStream(id, errSessionDeleted)
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if err := store.Delete(sessionKey(id)); err != nil {
+		writeInvalid(w, r, fmt.Errorf("%w: %v", errSessionStore, err))
+		return
+	}
+	sess.deleted = true
+	sess.endConversation()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestMessageIDs(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+
+	var replies []ChatReply
+	for _, msg := range []string{"one", "two"} {
+		_, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "ids", Message: msg})
+		var reply ChatReply
+		json.Unmarshal(body, &reply)
+		replies = append(replies, reply)
+	}
+	before := history(t, srv.URL, "ids")
+	if len(before) != 4 {
+		t.Fatalf("history has %d messages, want 4", len(before))
+	}
+	seen := map[string]bool{}
+	for _, m := range before {
+		if m.ID == "" || seen[m.ID] {
+			t.Errorf("message %+v: ID missing or repeated", m)
+		}
+		seen[m.ID] = true
+	}
+	for i, reply := range replies {
+		if reply.UserMessageID != before[2*i].ID || reply.MessageID != before[2*i+1].ID {
+			t.Errorf("turn %d: reply IDs %q/%q, history has %q/%q",
+				i, reply.UserMessageID, reply.MessageID, before[2*i].ID, before[2*i+1].ID)
+		}
+	}
+
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "ids", Message: "three"})
+	after := history(t, srv.URL, "ids")
+	for i, m := range before {
+		if after[i].ID != m.ID {
+			t.Errorf("message %d: ID changed from %q to %q", i, m.ID, after[i].ID)
+		}
+	}
+
+	for _, m := range upstream.LastRequest().Body["messages"].([]interface{}) {
+		if _, ok := m.(map[string]interface{})["id"]; ok {
+			t.Errorf("upstream payload carries a message ID: %v", m)
+		}
+	}
+}
+
+func TestDeleteMessage(t *testing.T) {
+	srv, upstream := newTestServer(t,
+		cerebrastest.Response{Content: "A1."},
+		cerebrastest.Response{Content: "A2."},
+		cerebrastest.Response{Content: "A3."},
+	)
+	for _, msg := range []string{"one", "two", "three"} {
+		do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "del", Message: msg})
+	}
+	msgs := history(t, srv.URL, "del")
+
+	// drop the middle turn, then the first reply on its own
+	resp, _ := do(t, "DELETE", srv.URL+"/api/message/"+msgs[2].ID+"?session_id=del&pair=true", nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete turn: status = %d, want 204", resp.StatusCode)
+	}
+	resp, _ = do(t, "DELETE", srv.URL+"/api/message/"+msgs[1].ID+"?session_id=del", nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete reply: status = %d, want 204", resp.StatusCode)
+	}
+	if got := contents(history(t, srv.URL, "del")); strings.Join(got, "|") != "one|three|A3." {
+		t.Errorf("history after deletes = %q", got)
+	}
+
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "del", Message: "four"})
+	var sent []string
+	for _, m := range sentMessages(t, upstream.LastRequest()) {
+		if m.Role != "system" {
+			sent = append(sent, m.Content)
+		}
+	}
+	if got := strings.Join(sent, "|"); got != "one|three|A3.|four" {
+		t.Errorf("next payload = %q, want the deleted messages left out", got)
+	}
+
+	resp, _ = do(t, "DELETE", srv.URL+"/api/message/"+msgs[2].ID+"?session_id=del", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("deleting an unknown ID: status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestHistoryHidesSystem(t *testing.T) {
+	srv, _ := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "sys", Message: "hi"})
+
+	for _, m := range history(t, srv.URL, "sys") {
+		if m.Role == "system" {
+			t.Errorf("history leaks the system prompt: %q", m.Content)
+		}
+	}
+
+	url := srv.URL + "/api/history?session_id=sys&include_system=true"
+	if resp, _ := do(t, "GET", url, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("include_system without admin token: status = %d, want 401", resp.StatusCode)
+	}
+	_, body := send(t, adminRequest(t, "GET", url))
+	var reply HistoryReply
+	json.Unmarshal(body, &reply)
+	if len(reply.Messages) != 3 || reply.Messages[0].Role != "system" || reply.Messages[0].Content == "" {
+		t.Errorf("admin history = %+v, want the system prompt first", reply.Messages)
+	}
+}
+
+func TestTruncatedInHistory(t *testing.T) {
+	srv, _ := newTestServer(t,
+		cerebrastest.Response{Content: "Cut off mid", FinishReason: "length"},
+		cerebrastest.Response{Content: "Complete."},
+	)
+	_, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "cut", Message: "one"})
+	var reply ChatReply
+	json.Unmarshal(body, &reply)
+	if !reply.Truncated {
+		t.Errorf("reply = %+v, want it flagged truncated", reply)
+	}
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "cut", Message: "two"})
+
+	msgs := history(t, srv.URL, "cut")
+	if !msgs[1].Truncated || msgs[1].Content != "Cut off mid …" {
+		t.Errorf("truncated reply in history = %+v, want the flag and note", msgs[1])
+	}
+	if msgs[3].Truncated || msgs[3].Content != "Complete." {
+		t.Errorf("complete reply in history = %+v", msgs[3])
+	}
+
+	t.Setenv("TRUNCATION_NOTE", " [cut off]")
+	if got := history(t, srv.URL, "cut")[1].Content; got != "Cut off mid [cut off]" {
+		t.Errorf("with TRUNCATION_NOTE: content = %q", got)
+	}
+}
+
+func TestResetDuringTurn(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "First."})
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "reset", Message: "one"})
+
+	for _, path := range []string{"/api/chat", "/api/chat/stream"} {
+		upstream.Enqueue(cerebrastest.Response{
+			Content:    "Slow.",
+			Delay:      200 * time.Millisecond,
+			Stream:     []string{"Slow ", "stream."},
+			ChunkDelay: 200 * time.Millisecond,
+		})
+		calls := len(upstream.Requests())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if resp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(`{"session_id": "reset", "message": "slow"}`)); err == nil {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+		}()
+		waitFor(t, "the slow turn to reach upstream", func() bool { return len(upstream.Requests()) > calls })
+
+		if resp, _ := do(t, "POST", srv.URL+"/api/reset", CancelRequest{SessionID: "reset"}); resp.StatusCode != http.StatusNoContent {
+			t.Errorf("%s: reset status = %d, want 204", path, resp.StatusCode)
+		}
+		<-done
+		if msgs := history(t, srv.URL, "reset"); len(msgs) != 0 {
+			t.Errorf("%s: history after reset = %+v, want nothing left over from the turn", path, msgs)
+		}
+	}
+
+	upstream.Enqueue(cerebrastest.Response{Content: "Fresh."})
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "reset", Message: "new"})
+	if sent := sentMessages(t, upstream.LastRequest()); len(sent) != 2 || sent[1].Content != "new" {
+		t.Errorf("payload after reset = %+v, want the system prompt and the new message", sent)
+	}
+}
+
+func TestDeleteSession(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Kept."})
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "gone", Message: "one"})
+	stale, _, _ := findSession("gone")
+
+	if resp, _ := do(t, "DELETE", srv.URL+"/api/session?session_id=gone", nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete status = %d, want 204", resp.StatusCode)
+	}
+	if resp, _ := do(t, "DELETE", srv.URL+"/api/session?session_id=gone", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("second delete status = %d, want 404", resp.StatusCode)
+	}
+
+	// a turn that looked the session up before the delete doesn't save it back
+	stale.mu.Lock()
+	saveSession("gone", stale)
+	stale.mu.Unlock()
+	if _, ok, _ := findSession("gone"); ok {
+		t.Error("deleted session was saved back")
+	}
+
+	upstream.Enqueue(cerebrastest.Response{Content: "Fresh."})
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "gone", Message: "new"})
+	if sent := sentMessages(t, upstream.LastRequest()); len(sent) != 2 || sent[1].Content != "new" {
+		t.Errorf("payload after delete = %+v, want the system prompt and the new message", sent)
+	}
+}
+
+func TestHistoryReadsDuringTrim(t *testing.T) {
+	srv, _ := newTestServer(t, cerebrastest.Response{Content: "Ok.", Delay: 10 * time.Millisecond})
+	t.Setenv("RING_CAPACITY", "4")
+	t.Setenv("COLLAPSE_INFLIGHT", "true")
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "busy", Message: "0"})
+
+	stop := make(chan struct{})
+	errs := make(chan error, 100)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				resp, err := http.Get(srv.URL + "/api/history?session_id=busy")
+				if err != nil {
+					errs <- err
+					return
+				}
+				var h HistoryReply
+				json.NewDecoder(resp.Body).Decode(&h)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					errs <- fmt.Errorf("history read: status %d", resp.StatusCode)
+					return
+				}
+				if n := len(h.Messages); n == 0 || n > 4 {
+					errs <- fmt.Errorf("history read saw %d messages with a ring of 4", n)
+					return
+				}
+			}
+		}()
+	}
+
+	// every turn past the second overwrites the oldest messages
+	for i := 1; i <= 6; i++ {
+		if resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "busy", Message: strconv.Itoa(i)}); resp.StatusCode != http.StatusOK {
+			t.Errorf("turn %d: status = %d, body %s", i, resp.StatusCode, body)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if got := strings.Join(contents(history(t, srv.URL, "busy")), "|"); got != "5|Ok.|6|Ok." {
+		t.Errorf("history after the trims = %q", got)
+	}
+}
@@ -0,0 +1,265 @@
+// Package cerebrastest provides an in-process fake of the Cerebras chat
+// completions API for handler tests, in the spirit of net/http/httptest.
+package cerebrastest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// Usage mirrors the upstream token usage block.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Response scripts one upstream reply. Zero values give a 200 completion
+// with empty content.
+type Response struct {
+	// Status defaults to 200.
+	Status int
+	// Headers are set on the reply before the body is written.
+	Headers map[string]string
+	// Delay is slept before anything is written.
+	Delay time.Duration
+
+	// Body, when set, is written verbatim and every other body field is
+	// ignored.
+	Body string
+	// Error writes an OpenAI-style {"error": {...}} envelope instead of a
+	// completion.
+	Error string
+
+	Content      string
+	Role         string // defaults to "assistant"
+	Model        string // defaults to the requested model
+	FinishReason string // defaults to "stop"
+	Created      int64
+	Usage        *Usage
+
+	// Stream holds the deltas sent when the request sets "stream": true.
+	// If nil, Content is sent as a single delta.
+	Stream []string
+	// ChunkDelay is slept before each streamed delta.
+	ChunkDelay time.Duration
+}
+
+// Request is an upstream call recorded by the server.
+type Request struct {
+	Path   string
+	Header http.Header
+	Body   map[string]interface{}
+}
+
+// Server is a fake Cerebras endpoint. Responses are served in the order
+// they were queued; the last one repeats once the queue is exhausted,
+// until more are queued.
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	responses []Response
+	requests  []Request
+	// repeating is set once the last queued response has been served.
+	repeating bool
+}
+
+// NewServer starts a fake that replies with responses in order.
+func NewServer(responses ...Response) *Server {
+	s := &Server{responses: responses}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Enqueue appends responses to the script. A response that is only
+// being repeated is dropped, so the new ones are served next.
+func (s *Server) Enqueue(responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.repeating {
+		s.responses, s.repeating = nil, false
+	}
+	s.responses = append(s.responses, responses...)
+}
+
+// Requests returns the calls received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// LastRequest returns the most recent call, or a zero Request.
+func (s *Server) LastRequest() Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.requests) == 0 {
+		return Request{}
+	}
+	return s.requests[len(s.requests)-1]
+}
+
+// next pops the next scripted response, repeating the last one.
+func (s *Server) next(req Request) Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, req)
+	if len(s.responses) == 0 {
+		return Response{}
+	}
+	resp := s.responses[0]
+	if len(s.responses) > 1 {
+		s.responses = s.responses[1:]
+	} else {
+		s.repeating = true
+	}
+	return resp
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	raw, _ := io.ReadAll(r.Body)
+	var body map[string]interface{}
+	json.Unmarshal(raw, &body)
+
+	resp := s.next(Request{Path: r.URL.Path, Header: r.Header.Clone(), Body: body})
+
+	if resp.Delay > 0 {
+		select {
+		case <-time.After(resp.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	for k, v := range resp.Headers {
+		w.Header().Set(k, v)
+	}
+	status := resp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	switch {
+	case resp.Body != "":
+		w.WriteHeader(status)
+		io.WriteString(w, resp.Body)
+	case resp.Error != "":
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": map[string]interface{}{
+				"message": resp.Error,
+				"type":    "invalid_request_error",
+				"code":    http.StatusText(status),
+			},
+		})
+	case body["stream"] == true:
+		s.writeStream(w, r, status, resp, body)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(completion(resp, body))
+	}
+}
+
+func (s *Server) writeStream(w http.ResponseWriter, r *http.Request, status int, resp Response, body map[string]interface{}) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(status)
+	flusher, _ := w.(http.Flusher)
+
+	deltas := resp.Stream
+	if deltas == nil {
+		deltas = []string{resp.Content}
+	}
+	for _, d := range deltas {
+		if resp.ChunkDelay > 0 {
+			select {
+			case <-time.After(resp.ChunkDelay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		chunk := map[string]interface{}{
+			"object": "chat.completion.chunk",
+			"model":  model(resp, body),
+			"choices": []interface{}{map[string]interface{}{
+				"index": 0,
+				"delta": map[string]string{"content": d},
+			}},
+		}
+		writeData(w, chunk)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	final := map[string]interface{}{
+		"object": "chat.completion.chunk",
+		"model":  model(resp, body),
+		"choices": []interface{}{map[string]interface{}{
+			"index":         0,
+			"delta":         map[string]string{},
+			"finish_reason": finishReason(resp),
+		}},
+	}
+	if resp.Usage != nil {
+		final["usage"] = resp.Usage
+	}
+	writeData(w, final)
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
+func writeData(w io.Writer, v interface{}) {
+	data, _ := json.Marshal(v)
+	fmt.Fprintf(w, "data: %s\n\n", data)
+}
+
+func completion(resp Response, body map[string]interface{}) map[string]interface{} {
+	role := resp.Role
+	if role == "" {
+		role = "assistant"
+	}
+	out := map[string]interface{}{
+		"id":      "chatcmpl-test",
+		"object":  "chat.completion",
+		"created": resp.Created,
+		"model":   model(resp, body),
+		"choices": []interface{}{map[string]interface{}{
+			"index":         0,
+			"finish_reason": finishReason(resp),
+			"message": map[string]string{
+				"role":    role,
+				"content": resp.Content,
+			},
+		}},
+	}
+	if resp.Usage != nil {
+		out["usage"] = resp.Usage
+	}
+	return out
+}
+
+func model(resp Response, body map[string]interface{}) string {
+	if resp.Model != "" {
+		return resp.Model
+	}
+	m, _ := body["model"].(string)
+	return m
+}
+
+func finishReason(resp Response) string {
+	if resp.FinishReason != "" {
+		return resp.FinishReason
+	}
+	return "stop"
+}
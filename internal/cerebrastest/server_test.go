@@ -0,0 +1,213 @@
+package cerebrastest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func post(t *testing.T, s *Server, body string) (*http.Response, string) {
+	t.Helper()
+	req, _ := http.NewRequest("POST", s.URL+"/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer k")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp, string(data)
+}
+
+type completionBody struct {
+	Created int64  `json:"created"`
+	Model   string `json:"model"`
+	Choices []struct {
+		FinishReason string `json:"finish_reason"`
+		Message      struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+	Usage *Usage `json:"usage"`
+}
+
+func TestCompletion(t *testing.T) {
+	s := NewServer(Response{
+		Content:      "Hello.",
+		Role:         "bot",
+		FinishReason: "length",
+		Created:      1700000000,
+		Usage:        &Usage{PromptTokens: 5, CompletionTokens: 2, TotalTokens: 7},
+	})
+	defer s.Close()
+
+	resp, body := post(t, s, `{"model": "m1", "messages": []}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	var c completionBody
+	if err := json.Unmarshal([]byte(body), &c); err != nil {
+		t.Fatal(err)
+	}
+	m := c.Choices[0]
+	if m.Message.Content != "Hello." || m.Message.Role != "bot" || m.FinishReason != "length" {
+		t.Errorf("choice = %+v", m)
+	}
+	if c.Model != "m1" {
+		t.Errorf("model = %q, want the requested one echoed", c.Model)
+	}
+	if c.Created != 1700000000 || c.Usage == nil || c.Usage.TotalTokens != 7 {
+		t.Errorf("created = %d, usage = %+v", c.Created, c.Usage)
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	s := NewServer(Response{Model: "other"})
+	defer s.Close()
+
+	_, body := post(t, s, `{"model": "m1"}`)
+	var c completionBody
+	json.Unmarshal([]byte(body), &c)
+	if c.Model != "other" || c.Choices[0].Message.Role != "assistant" || c.Choices[0].FinishReason != "stop" {
+		t.Errorf("got %s", body)
+	}
+	if c.Usage != nil {
+		t.Errorf("usage = %+v, want none", c.Usage)
+	}
+}
+
+func TestStatusHeadersAndBody(t *testing.T) {
+	s := NewServer(Response{
+		Status:  http.StatusBadGateway,
+		Headers: map[string]string{"X-Ratelimit-Remaining-Requests": "9"},
+		Body:    "not json",
+	})
+	defer s.Close()
+
+	resp, body := post(t, s, `{}`)
+	if resp.StatusCode != http.StatusBadGateway || body != "not json" {
+		t.Errorf("status = %d, body = %q", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("X-Ratelimit-Remaining-Requests"); got != "9" {
+		t.Errorf("header = %q", got)
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	s := NewServer(Response{Status: http.StatusTooManyRequests, Error: "slow down"})
+	defer s.Close()
+
+	resp, body := post(t, s, `{}`)
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("status = %d", resp.StatusCode)
+	}
+	var e struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	json.Unmarshal([]byte(body), &e)
+	if e.Error.Message != "slow down" {
+		t.Errorf("body = %s", body)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	s := NewServer(Response{Delay: 50 * time.Millisecond})
+	defer s.Close()
+
+	start := time.Now()
+	post(t, s, `{}`)
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("replied after %s, want at least the delay", d)
+	}
+}
+
+func TestStream(t *testing.T) {
+	s := NewServer(Response{
+		Stream:     []string{"Hel", "lo."},
+		ChunkDelay: 10 * time.Millisecond,
+		Usage:      &Usage{TotalTokens: 3},
+	})
+	defer s.Close()
+
+	resp, body := post(t, s, `{"model": "m1", "stream": true}`)
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("content type = %q", ct)
+	}
+
+	var deltas []string
+	var finish string
+	var usage *Usage
+	lines := strings.Split(strings.TrimSpace(body), "\n\n")
+	for _, line := range lines[:len(lines)-1] {
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+				FinishReason string `json:"finish_reason"`
+			} `json:"choices"`
+			Usage *Usage `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &chunk); err != nil {
+			t.Fatalf("bad chunk %q: %v", line, err)
+		}
+		if d := chunk.Choices[0].Delta.Content; d != "" {
+			deltas = append(deltas, d)
+		}
+		if f := chunk.Choices[0].FinishReason; f != "" {
+			finish = f
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+	}
+	if got := strings.Join(deltas, "|"); got != "Hel|lo." {
+		t.Errorf("deltas = %q", got)
+	}
+	if finish != "stop" || usage == nil || usage.TotalTokens != 3 {
+		t.Errorf("finish = %q, usage = %+v", finish, usage)
+	}
+	if last := lines[len(lines)-1]; last != "data: [DONE]" {
+		t.Errorf("stream ends with %q", last)
+	}
+}
+
+func TestScriptAndRecording(t *testing.T) {
+	s := NewServer(Response{Content: "one"})
+	defer s.Close()
+	s.Enqueue(Response{Content: "two"})
+
+	var got []string
+	for _, model := range []string{"a", "b", "c"} {
+		_, body := post(t, s, `{"model": "`+model+`"}`)
+		var c completionBody
+		json.Unmarshal([]byte(body), &c)
+		got = append(got, c.Choices[0].Message.Content)
+	}
+	// the last response repeats once the script runs out
+	if strings.Join(got, " ") != "one two two" {
+		t.Errorf("replies = %v", got)
+	}
+	s.Enqueue(Response{Content: "three"})
+	_, body := post(t, s, `{"model": "d"}`)
+	var c completionBody
+	json.Unmarshal([]byte(body), &c)
+	if got := c.Choices[0].Message.Content; got != "three" {
+		t.Errorf("after Enqueue got %q, want the new response", got)
+	}
+
+	reqs := s.Requests()
+	if len(reqs) != 4 {
+		t.Fatalf("recorded %d requests, want 4", len(reqs))
+	}
+	last := s.LastRequest()
+	if last.Path != "/v1/chat/completions" || last.Body["model"] != "d" || last.Header.Get("Authorization") != "Bearer k" {
+		t.Errorf("last request = %+v", last)
+	}
+}
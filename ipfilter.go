@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+var (
+	ipAllowlist = parsePrefixes("IP_ALLOWLIST")
+	ipDenylist  = parsePrefixes("IP_DENYLIST")
+)
+
+// parsePrefixes reads a comma-separated list of CIDRs or bare IPs from
+// the environment. Invalid entries are logged and skipped.
+func parsePrefixes(key string) []netip.Prefix {
+	var out []netip.Prefix
+	for _, entry := range envList(key, nil) {
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				logger.Warn("ignoring invalid IP", "env", key, "entry", entry)
+				continue
+			}
+			out = append(out, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(entry)
+		if err != nil {
+			logger.Warn("ignoring invalid CIDR", "env", key, "entry", entry)
+			continue
+		}
+		out = append(out, p.Masked())
+	}
+	return out
+}
+
+func containsIP(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipAllowed applies the denylist first, then the allowlist if one is set.
+func ipAllowed(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		// unparseable peers only get through when no lists are configured
+		return len(ipAllowlist) == 0 && len(ipDenylist) == 0
+	}
+	addr = addr.Unmap()
+
+	if containsIP(ipDenylist, addr) {
+		return false
+	}
+	return len(ipAllowlist) == 0 || containsIP(ipAllowlist, addr)
+}
+
+// withIPFilter rejects disallowed client IPs with 403 before any other
+// processing.
+func withIPFilter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ipAllowed(clientIP(r)) {
+			writeErrorStatus(w, r, http.StatusForbidden, "Forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
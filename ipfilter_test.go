@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestIPAllowed(t *testing.T) {
+	t.Setenv("IP_ALLOWLIST", "10.0.0.0/8, 192.168.1.5, 2001:db8::/32")
+	t.Setenv("IP_DENYLIST", "10.9.0.0/16")
+	setVar(t, &ipAllowlist, parsePrefixes("IP_ALLOWLIST"))
+	setVar(t, &ipDenylist, parsePrefixes("IP_DENYLIST"))
+
+	for ip, want := range map[string]bool{
+		"10.1.2.3":        true,
+		"10.9.1.1":        false, // denied range inside the allowed one
+		"192.168.1.5":     true,
+		"192.168.1.6":     false,
+		"::ffff:10.1.2.3": true, // IPv4-mapped
+		"2001:db8::1":     true,
+		"2001:db9::1":     false,
+		"not-an-ip":       false,
+		"172.16.0.1":      false,
+	} {
+		if got := ipAllowed(ip); got != want {
+			t.Errorf("ipAllowed(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func TestIPFilterMiddleware(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	t.Setenv("IP_DENYLIST", "127.0.0.0/8")
+	setVar(t, &ipDenylist, parsePrefixes("IP_DENYLIST"))
+	if resp, _ := do(t, "GET", srv.URL+"/health", nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("denied client: status = %d, want 403", resp.StatusCode)
+	}
+
+	ipDenylist = nil
+	t.Setenv("IP_ALLOWLIST", "127.0.0.1")
+	setVar(t, &ipAllowlist, parsePrefixes("IP_ALLOWLIST"))
+	if resp, _ := do(t, "GET", srv.URL+"/health", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("allowed client: status = %d, want 200", resp.StatusCode)
+	}
+}
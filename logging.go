@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"time"
+)
+
+// logger emits structured JSON records for analysis; plain log is kept for
+// startup and fatal messages.
+var logger = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+
+// logTurn records the exact parameters, usage and latency of a completed
+// turn so experiments can be joined on them later.
+func logTurn(ctx context.Context, endpoint string, params GenParams, usage Usage, latency time.Duration, extra ...slog.Attr) {
+	attrs := []slog.Attr{
+		slog.String("request_id", requestIDFrom(ctx)),
+		slog.String("endpoint", endpoint),
+		slog.String("model", params.Model),
+		slog.Int("max_tokens", params.MaxTokens),
+		slog.Int("prompt_tokens", usage.PromptTokens),
+		slog.Int("completion_tokens", usage.CompletionTokens),
+		slog.Int("total_tokens", usage.TotalTokens),
+		slog.Int64("latency_ms", latency.Milliseconds()),
+	}
+	if params.Temperature != nil {
+		attrs = append(attrs, slog.Float64("temperature", *params.Temperature))
+	}
+	if params.TopP != nil {
+		attrs = append(attrs, slog.Float64("top_p", *params.TopP))
+	}
+	attrs = append(attrs, extra...)
+	logger.LogAttrs(ctx, slog.LevelInfo, "turn completed", attrs...)
+}
+
+// logSampleRate is the fraction (0..1) of successful requests that get an
+// access log line. Failed requests are always logged.
+var logSampleRate = envFloat("LOG_SAMPLE_RATE", 1)
+
+// sampled reports whether a successful request should be logged.
+func sampled() bool {
+	return logSampleRate >= 1 || rand.Float64() < logSampleRate
+}
+
+// withRequestLog writes one access log record per request. Successes are
+// sampled at LOG_SAMPLE_RATE; 4xx and 5xx responses are always logged.
+func withRequestLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(sr, r)
+
+		status := sr.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		case !sampled():
+			return
+		}
+
+		logger.LogAttrs(r.Context(), level, "request",
+			slog.String("request_id", requestIDFrom(r.Context())),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.String("client_ip", clientIP(r)),
+			slog.Int64("duration_ms", time.Since(start).Milliseconds()),
+		)
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+// logBuffer is a goroutine-safe sink for the JSON logger.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// records returns the logged records with the given message.
+func (b *logBuffer) records(t *testing.T, msg string) []map[string]interface{} {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []map[string]interface{}
+	sc := bufio.NewScanner(bytes.NewReader(b.buf.Bytes()))
+	for sc.Scan() {
+		var rec map[string]interface{}
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("bad log line %q: %v", sc.Text(), err)
+		}
+		if rec["msg"] == msg {
+			out = append(out, rec)
+		}
+	}
+	return out
+}
+
+// captureLogs sends the logger's output to a buffer for the test.
+func captureLogs(t *testing.T) *logBuffer {
+	t.Helper()
+	b := &logBuffer{}
+	setVar(t, &logger, slog.New(slog.NewJSONHandler(b, nil)))
+	return b
+}
+
+func TestTurnLogRecord(t *testing.T) {
+	srv, _ := newTestServer(t, cerebrastest.Response{
+		Content: "Logged.",
+		Usage:   &cerebrastest.Usage{PromptTokens: 20, CompletionTokens: 4, TotalTokens: 24},
+	})
+	logs := captureLogs(t)
+
+	temp, topP := 0.3, 0.7
+	resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{
+		SessionID: "logged",
+		Message:   "hi",
+		GenParams: GenParams{Temperature: &temp, TopP: &topP, MaxTokens: 64},
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+	}
+
+	recs := logs.records(t, "turn completed")
+	if len(recs) != 1 {
+		t.Fatalf("got %d turn records, want 1", len(recs))
+	}
+	rec := recs[0]
+	for field, want := range map[string]interface{}{
+		"endpoint":          "chat",
+		"model":             "gpt-oss-120b",
+		"temperature":       0.3,
+		"top_p":             0.7,
+		"max_tokens":        64.0,
+		"prompt_tokens":     20.0,
+		"completion_tokens": 4.0,
+		"total_tokens":      24.0,
+		"request_id":        resp.Header.Get("X-Request-ID"),
+	} {
+		if rec[field] != want {
+			t.Errorf("%s = %v, want %v", field, rec[field], want)
+		}
+	}
+	if _, ok := rec["latency_ms"].(float64); !ok {
+		t.Errorf("latency_ms missing from %v", rec)
+	}
+}
+
+func TestLogSampling(t *testing.T) {
+	logs := captureLogs(t)
+	setVar(t, &logSampleRate, 0.2)
+	h := withRequestLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+
+	const n = 1000
+	for i := 0; i < n; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ok", nil))
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fail", nil))
+	}
+	var ok, failed int
+	for _, rec := range logs.records(t, "request") {
+		if rec["path"] == "/ok" {
+			ok++
+		} else {
+			failed++
+		}
+	}
+	// 200 expected; the bounds are over six standard deviations out
+	if ok < 120 || ok > 280 {
+		t.Errorf("logged %d of %d successes at rate 0.2", ok, n)
+	}
+	if failed != n {
+		t.Errorf("logged %d of %d errors, want all", failed, n)
+	}
+}
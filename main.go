@@ -1,19 +1,31 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
-	"sync"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type Message struct {
+	// ID is unique within a session and stable for the message's
+	// lifetime. Never sent upstream.
+	ID      string `json:"id,omitempty"`
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// Truncated marks replies cut short by a cap. Never sent upstream.
+	Truncated bool `json:"truncated,omitempty"`
 }
 
 type ChatResponse struct {
@@ -29,23 +41,85 @@ type ChatResponse struct {
 			Content string `json:"content"`
 		} `json:"message"`
 	} `json:"choices"`
+	Usage Usage `json:"usage"`
+
+	// rateLimits are the normalized upstream rate-limit headers.
+	rateLimits http.Header
+	// retries counts the failed attempts before this response succeeded.
+	retries int
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
 }
 
 type ChatRequest struct {
-	Message string `json:"message"`
+	Message   string `json:"message"`
+	SessionID string `json:"session_id,omitempty"`
+	Persona   string `json:"persona,omitempty"`
+	// Vars fill template variables in the persona's system prompt when
+	// the session is first seeded.
+	Vars map[string]string `json:"vars,omitempty"`
+	// NoSystem starts the session without any system prompt. Only honoured
+	// when ALLOW_NO_SYSTEM is set.
+	NoSystem bool `json:"no_system,omitempty"`
+	// Context snippets are shown to the model for this turn only and are
+	// never stored in the session.
+	Context []string `json:"context,omitempty"`
+	// Language forces replies into one of SUPPORTED_LANGUAGES.
+	Language string `json:"language,omitempty"`
+	// Raw asks for the parsed upstream response alongside the reply.
+	Raw bool `json:"raw,omitempty"`
+	// HistoryWindow limits this turn's context to the most recent N stored
+	// messages, capped at MAX_HISTORY_WINDOW. Zero uses everything the
+	// token budget allows.
+	HistoryWindow int `json:"history_window,omitempty"`
+	// EchoMessage asks for the user message, as stored after
+	// normalization, to be returned in the reply.
+	EchoMessage bool `json:"echo_message,omitempty"`
+	// AssistantPrefix prefills the start of the reply for the model to
+	// continue from.
+	AssistantPrefix string `json:"assistant_prefix,omitempty"`
+	// SystemAppend is an extra instruction added to the system prompt for
+	// this turn only; it is never stored. Only honoured when
+	// ALLOW_SYSTEM_APPEND is set.
+	SystemAppend string `json:"system_append,omitempty"`
+	GenParams
 }
 
 type ChatReply struct {
-	Reply string `json:"reply"`
-	Error string `json:"error,omitempty"`
+	Reply     string `json:"reply"`
+	Error     string `json:"error,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+	// Created is when the reply was generated, in Unix seconds: upstream's
+	// timestamp, or ours if it sent none.
+	Created int64 `json:"created,omitempty"`
+	// SystemHash identifies the system prompt the reply was generated
+	// under; it changes whenever the persona prompt does.
+	SystemHash string `json:"system_hash,omitempty"`
+	// Model is the model upstream reports having used, with
+	// VALIDATE_MODEL_ECHO on.
+	Model string `json:"model,omitempty"`
+	// Field is the request field an error refers to, e.g.
+	// "messages[2].role", when it can be pinned to one.
+	Field string `json:"field,omitempty"`
+	// Message is the stored user message, when echo_message was set.
+	Message string `json:"message,omitempty"`
+	// Fallback marks FALLBACK_REPLY served in place of an upstream error.
+	Fallback bool `json:"fallback,omitempty"`
+	// Blocked marks the persona's dismissal served in place of a message
+	// INJECTION_GUARD rejected; nothing was sent upstream or stored.
+	Blocked bool `json:"blocked,omitempty"`
+	// MessageID identifies the stored reply, UserMessageID the message it
+	// answers.
+	MessageID     string `json:"message_id,omitempty"`
+	UserMessageID string `json:"user_message_id,omitempty"`
+	// Raw is the full upstream response, only included on request.
+	Raw *ChatResponse `json:"raw,omitempty"`
 }
 
-// global conversation for now (single user demo)
-var (
-	messages []Message
-	mu       sync.Mutex
-)
-
 const BODHA_ROAST_SYSTEM_PROMPT = `
 	You are Bodha — a ruthless, sharp-minded AI agent that roasts questions aggressively before answering.
 
@@ -85,155 +159,429 @@ const BODHA_ROAST_SYSTEM_PROMPT = `
 `
 
 func main() {
+	runSelftest := flag.Bool("selftest", false, "run one completion against Cerebras, print the result and exit")
+	flag.Parse()
+
+	if *runSelftest || envBool("SELFTEST", false) {
+		os.Exit(runSelftestMode())
+	}
+
 	apiKey := os.Getenv("CEREBRAS_API_KEY")
 	if apiKey == "" {
 		fmt.Println("Missing CEREBRAS_API_KEY environment variable")
 		return
 	}
 
-	// init conversation with a system message
-	messages = []Message{
-		{Role: "system", Content: BODHA_ROAST_SYSTEM_PROMPT},
+	shutdownTracing, err := initTracing(context.Background())
+	if err != nil {
+		log.Fatalf("tracing setup error: %v", err)
 	}
 
-	http.HandleFunc("/api/chat", func(w http.ResponseWriter, r *http.Request) {
-		// ✅ CORS FIRST — ALWAYS
-		enableCORS(w, r)
+	storeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	store, err = newSessionStore(storeCtx)
+	cancel()
+	if err != nil {
+		log.Fatalf("session store error: %v", err)
+	}
 
-		// ✅ Handle preflight
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+	if envBool("WARMUP_ON_START", false) {
+		ctx, cancel := context.WithTimeout(context.Background(), envDuration("WARMUP_TIMEOUT", 3*time.Second))
+		warmup(ctx)
+		cancel()
+	}
 
-		if r.Method != http.MethodPost {
-			http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
-			return
-		}
+	// greetings are generated in the background; until one lands, the
+	// endpoint generates on demand as usual
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), envDuration("PREWARM_TIMEOUT", 30*time.Second))
+		defer cancel()
+		prewarmGreetings(ctx)
+	}()
 
-		var req ChatRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			writeError(w, "Invalid JSON: "+err.Error())
-			return
-		}
-		if req.Message == "" {
-			writeError(w, "Message is required")
-			return
-		}
+	port := os.Getenv("PORT")
+	if port == "" {
+		// Local dev fallback
+		port = "8080"
+	}
 
-		mu.Lock()
-		defer mu.Unlock()
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: newHandler(),
+	}
 
-		if len(messages) > 10 {
-			resetConversation()
-		}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		messages = append(messages, Message{
-			Role:    "user",
-			Content: req.Message,
-		})
-
-		// available models
-		// - gpt-oss-120b
-		// - zai-glm-4.7
-		payload := map[string]interface{}{
-			"model":       "gpt-oss-120b",
-			"messages":    messages,
-			"temperature": 0.8,
-			"top_p":       0.9,
-			"max_tokens":  512,
-		}
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Starting server %s on :%s\n", version, port)
+		serveErr <- srv.ListenAndServe()
+	}()
 
-		jsonData, err := json.Marshal(payload)
-		if err != nil {
-			writeError(w, "Marshal error: "+err.Error())
-			return
-		}
+	select {
+	case err := <-serveErr:
+		shutdownTracing(context.Background())
+		log.Fatalf("server error: %v", err)
+	case <-ctx.Done():
+	}
 
-		httpReq, err := http.NewRequest(
-			"POST",
-			"https://api.cerebras.ai/v1/chat/completions",
-			bytes.NewBuffer(jsonData),
-		)
-		if err != nil {
-			writeError(w, "Request creation error: "+err.Error())
-			return
-		}
+	log.Println("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), envDuration("SHUTDOWN_TIMEOUT", 10*time.Second))
+	defer cancel()
+	shutdown(shutdownCtx, srv, shutdownTracing)
+}
 
-		httpReq.Header.Set("Content-Type", "application/json")
-		httpReq.Header.Set("Authorization", "Bearer "+os.Getenv("CEREBRAS_API_KEY"))
+// runSelftestMode runs the selftest instead of the server and returns the
+// process exit code: non-zero on any failure, a missing key included since
+// that's the misconfiguration the selftest exists to catch.
+func runSelftestMode() int {
+	if os.Getenv("CEREBRAS_API_KEY") == "" {
+		fmt.Println("Missing CEREBRAS_API_KEY environment variable")
+		return 1
+	}
 
-		resp, err := http.DefaultClient.Do(httpReq)
-		if err != nil {
-			writeError(w, "API call error: "+err.Error())
+	shutdownTracing, err := initTracing(context.Background())
+	if err != nil {
+		fmt.Println("tracing setup error:", err)
+		return 1
+	}
+	defer shutdownTracing(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), envDuration("SELFTEST_TIMEOUT", 30*time.Second))
+	defer cancel()
+	if err := selftest(ctx); err != nil {
+		fmt.Println("selftest failed:", err)
+		return 1
+	}
+	return 0
+}
+
+// shutdown lets in-flight requests on srv finish, then flushes buffered
+// spans through shutdownTracing, all within ctx, and closes the audit log.
+// Errors are logged rather than returned since the process is exiting
+// either way.
+func shutdown(ctx context.Context, srv *http.Server, shutdownTracing func(context.Context) error) {
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("server shutdown error: %v", err)
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("tracing shutdown error: %v", err)
+	}
+	if err := closeAudit(); err != nil {
+		log.Printf("audit log close error: %v", err)
+	}
+}
+
+// newHandler registers every route behind the middleware all requests
+// share.
+func newHandler() http.Handler {
+	mux := http.NewServeMux()
+	// method patterns make the mux answer mismatches with 405 and an Allow
+	// header, so handlers only see the methods they registered for
+	mux.HandleFunc("POST /api/chat", chatRoute(handleChat))
+	mux.HandleFunc("GET /api/chat/stream", chatRoute(handleChatStream))
+	mux.HandleFunc("POST /api/chat/stream", chatRoute(handleChatStream))
+	mux.HandleFunc("POST /api/chat/stateless", chatRoute(handleStateless))
+	mux.HandleFunc("POST /api/regenerate", chatRoute(handleRegenerate))
+	mux.HandleFunc("POST /api/edit-last", chatRoute(handleEditLast))
+	mux.HandleFunc("POST /api/cancel", handleCancel)
+	mux.HandleFunc("POST /api/reset", handleReset)
+	mux.HandleFunc("DELETE /api/session", handleDeleteSession)
+	mux.HandleFunc("POST /api/session/fork", handleFork)
+	mux.HandleFunc("GET /api/session/title", chatRoute(handleTitle))
+	mux.HandleFunc("GET /api/greeting", chatRoute(handleGreeting))
+	mux.HandleFunc("GET /api/history", handleHistory)
+	mux.HandleFunc("GET /api/stats", handleStats)
+	mux.HandleFunc("DELETE /api/message/{id}", handleDeleteMessage)
+	mux.HandleFunc("GET /api/config", requireAdmin(handleConfig))
+	mux.HandleFunc("GET /admin/maintenance", requireAdmin(handleMaintenance))
+	mux.HandleFunc("POST /admin/maintenance", requireAdmin(handleMaintenance))
+	mux.HandleFunc("GET /admin/sessions", requireAdmin(handleSessions))
+	mux.HandleFunc("GET /health", handleHealth)
+	mux.HandleFunc("GET /version", handleVersion)
+	mux.Handle("GET /metrics", promhttp.Handler())
+	return withRequestID(withRequestLog(withIPFilter(withTracing(withCORS(mux)))))
+}
+
+// chatRoute wraps handlers that spend Cerebras quota with the checks every
+// such endpoint shares.
+func chatRoute(h http.HandlerFunc) http.HandlerFunc {
+	return withMaintenance(withSignature(withDailyQuota(withDeadline(h))))
+}
+
+// withDeadline bounds the whole handler, retries included, by
+// MAX_REQUEST_DURATION. Upstream calls inherit the deadline from the
+// request context. Zero disables it.
+func withDeadline(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d := envDuration("MAX_REQUEST_DURATION", 0)
+		if d <= 0 {
+			next(w, r)
 			return
 		}
-		defer resp.Body.Close()
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next(w, r.WithContext(ctx))
+	}
+}
+
+func handleChat(w http.ResponseWriter, r *http.Request) {
+	if negotiate(r) == mediaStream {
+		handleChatStream(w, r)
+		return
+	}
+
+	var req ChatRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeErrorStatus(w, r, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	req.SessionID = withCookieSession(w, r, req.SessionID)
+	req.Message = normalizeMessage(req.Message)
+	if req.Message == "" {
+		writeInvalid(w, r, invalid("message", "is required"))
+		return
+	}
+	if err := validateTurnOptions(&req); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	sess, err := getSession(&req)
+	if err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	if !sess.lockTurn() {
+		writeErrorStatus(w, r, http.StatusConflict, "request in progress")
+		return
+	}
+	defer sess.unlockTurn()
+	defer saveSession(req.SessionID, sess)
+	if err := sess.checkTurn(&req); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
 
-		body, err := io.ReadAll(resp.Body)
+	if blockInjection(r.Context(), req.Message) {
+		writeReply(w, r, ChatReply{Reply: sess.turnDismissal(&req), Blocked: true})
+		return
+	}
+
+	sess.append(Message{
+		Role:    "user",
+		Content: req.Message,
+	})
+	runTurn(w, r, sess, &req, "chat")
+}
+
+// validateTurnOptions checks the per-turn extras shared by every endpoint
+// that takes a ChatRequest, normalizing them in place.
+func validateTurnOptions(req *ChatRequest) error {
+	if err := validatePromptLength("message", req.Message); err != nil {
+		return err
+	}
+	if req.SystemAppend != "" {
+		if !envBool("ALLOW_SYSTEM_APPEND", false) {
+			return invalid("system_append", "not allowed on this server")
+		}
+		if err := validatePromptLength("system_append", req.SystemAppend); err != nil {
+			return err
+		}
+	}
+	if err := validateContextDocs(req.Context); err != nil {
+		return &FieldError{Field: "context", Msg: err.Error()}
+	}
+	if req.Language != "" {
+		lang, err := resolveLanguage(req.Language)
 		if err != nil {
-			writeError(w, "Read response error: "+err.Error())
-			return
+			return &FieldError{Field: "language", Msg: err.Error()}
 		}
+		req.Language = lang
+	}
+	if req.HistoryWindow < 0 {
+		return invalid("history_window", "must not be negative")
+	}
+	if max := envInt("MAX_HISTORY_WINDOW", 50); req.HistoryWindow > max {
+		req.HistoryWindow = max
+	}
+	if _, ok := personas[req.Persona]; req.Persona != "" && !ok {
+		return invalid("persona", "unknown persona %q", req.Persona)
+	}
+	return validateGenParams(req.GenParams)
+}
 
-		if resp.StatusCode != http.StatusOK {
-			writeError(w, fmt.Sprintf("API error (%s): %s", resp.Status, body))
-			return
-		}
+// runTurn completes the conversation as it currently stands in sess,
+// stores the reply and writes it to the client. Callers must hold sess.mu.
+func runTurn(w http.ResponseWriter, r *http.Request, sess *session, req *ChatRequest, endpoint string) {
+	conv, params, err := sess.forTurn(req)
+	if err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	msgs := withAssistantPrefix(withContextDocs(withLanguage(conv, req.Language), req.Context), req.AssistantPrefix)
 
-		var apiRes ChatResponse
-		if err := json.Unmarshal(body, &apiRes); err != nil {
-			writeError(w, "Unmarshal error: "+err.Error())
+	start := time.Now()
+	apiRes, err := complete(r.Context(), msgs, params)
+	if err != nil {
+		writeUpstreamFailure(w, r, err)
+		return
+	}
+
+	reply := apiRes.Choices[0].Message.Content
+	if strings.TrimSpace(reply) == "" && envBool("RETRY_ON_EMPTY", false) {
+		if again, err := complete(r.Context(), msgs, params); err == nil {
+			apiRes = again
+			reply = again.Choices[0].Message.Content
+		}
+		if strings.TrimSpace(reply) == "" {
+			// don't poison the history with an empty assistant turn
+			logTurn(r.Context(), endpoint, params, apiRes.Usage, time.Since(start))
+			writeReply(w, r, ChatReply{Reply: emptyReplyFallback()})
 			return
 		}
+	}
+	// the model continues the prefix; store and return the whole reply
+	if req.AssistantPrefix != "" && !strings.HasPrefix(reply, req.AssistantPrefix) {
+		reply = req.AssistantPrefix + reply
+	}
+	if min := envInt("MIN_REPLY_CHARS", 0); min > 0 && replyLen(reply) < min {
+		reply = retryFuller(r.Context(), msgs, params, reply)
+	}
+	if envBool("ENFORCE_ONELINE_RETRY", false) && isMultiline(reply) && !jsonMode(params) {
+		reply = retryOneLine(r.Context(), msgs, params, reply)
+	}
+	userMsg, _ := sess.history.last()
+	if !jsonMode(params) {
+		// the persona only explains when asked; enforce it when the model doesn't
+		if envBool("NO_EXPLAIN_FILTER", false) && !asksForExplanation(userMsg.Content) {
+			reply = firstSentence(reply)
+		}
+		reply = simplifyReply(reply)
+	}
+	if err := checkJSONReply(params, reply); err != nil {
+		logTurn(r.Context(), endpoint, params, apiRes.Usage, time.Since(start))
+		writeErrorStatus(w, r, http.StatusBadGateway, err.Error())
+		return
+	}
 
-		reply := apiRes.Choices[0].Message.Content
-
-		messages = append(messages, Message{
-			Role:    "assistant",
-			Content: reply,
-		})
+	// keep history faithful to the role the model actually returned
+	role := apiRes.Choices[0].Message.Role
+	if role == "" {
+		role = "assistant"
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(ChatReply{Reply: reply})
+	// finish_reason "length" means max_tokens cut the reply off
+	truncated := apiRes.Choices[0].FinishReason == "length"
+	replyID := sess.append(Message{
+		Role:      role,
+		Content:   reply,
+		Truncated: truncated,
 	})
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		// Local dev fallback
-		port = "8080"
+	logTurn(r.Context(), endpoint, params, apiRes.Usage, time.Since(start))
+	writeAudit(r.Context(), auditRecord{SessionID: sessionKey(req.SessionID), Endpoint: endpoint, Message: userMsg.Content, Reply: reply})
+	forwardRateLimits(w, apiRes.rateLimits)
+	w.Header().Set("X-Retry-Count", strconv.Itoa(apiRes.retries))
+	out := ChatReply{Reply: reply, Truncated: truncated, Created: createdAt(apiRes), SystemHash: systemHash(conv), Model: echoedModel(apiRes), MessageID: replyID, UserMessageID: userMsg.ID}
+	if req.EchoMessage {
+		out.Message = userMsg.Content
 	}
+	if wantRaw(r, req.Raw) {
+		out.Raw = apiRes
+	}
+	writeReply(w, r, out)
+}
 
-	log.Printf("Starting server on :%s\n", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatalf("server error: %v", err)
+// emptyReplyFallback is sent in place of a reply that is still empty
+// after RETRY_ON_EMPTY's retry.
+func emptyReplyFallback() string {
+	return envString("EMPTY_REPLY_FALLBACK", "Nothing to say to that. Try again.")
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, msg string) {
+	writeErrorStatus(w, r, http.StatusInternalServerError, msg)
+}
+
+// writeUpstreamError reports a failed upstream call: 503 while the circuit
+// is open, 504 when the request ran out of time, 502 for an oversized
+// upstream body and 500 otherwise, along with any rate limits upstream sent
+// with the failure and the retries spent on it.
+func writeUpstreamError(w http.ResponseWriter, r *http.Request, err error) {
+	forwardRateLimits(w, rateLimitsOf(err))
+	if retries, ok := retriesOf(err); ok {
+		w.Header().Set("X-Retry-Count", strconv.Itoa(retries))
+	}
+	if errors.Is(err, errCircuitOpen) {
+		if retry := int(time.Until(breaker.retryAt()).Seconds()) + 1; retry > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(retry))
+		}
+		writeErrorStatus(w, r, http.StatusServiceUnavailable, err.Error())
+		return
 	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeErrorStatus(w, r, http.StatusGatewayTimeout, "Request timed out: "+err.Error())
+		return
+	}
+	if errors.Is(err, errUpstreamTooLarge) {
+		writeErrorStatus(w, r, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeError(w, r, err.Error())
 }
 
-func resetConversation() {
-	messages = []Message{
-		{
-			Role:    "system",
-			Content: BODHA_ROAST_SYSTEM_PROMPT,
-		},
+// createdAt returns the upstream generation time, falling back to now.
+func createdAt(apiRes *ChatResponse) int64 {
+	if apiRes.Created > 0 {
+		return apiRes.Created
 	}
+	return time.Now().Unix()
 }
 
-func writeError(w http.ResponseWriter, msg string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusInternalServerError)
-	json.NewEncoder(w).Encode(ChatReply{Error: msg})
+// writeUpstreamFailure reports a failed completion. With
+// USE_FALLBACK_REPLY on, the client gets FALLBACK_REPLY with a 200 instead,
+// so the UI degrades gracefully; nothing is stored.
+func writeUpstreamFailure(w http.ResponseWriter, r *http.Request, err error) {
+	if !envBool("USE_FALLBACK_REPLY", false) {
+		writeUpstreamError(w, r, err)
+		return
+	}
+	logger.Warn("upstream failed, serving fallback reply", "request_id", requestIDFrom(r.Context()), "error", err)
+	writeReply(w, r, ChatReply{
+		Reply:    envString("FALLBACK_REPLY", "I can't answer right now. Please try again in a moment."),
+		Fallback: true,
+	})
 }
 
-func enableCORS(w http.ResponseWriter, r *http.Request) {
-	origin := r.Header.Get("Origin")
+func writeErrorStatus(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	writeErrorReply(w, r, status, ChatReply{Error: msg})
+}
 
-	if origin == "https://dibinxavier.github.io" ||
-		origin == "http://localhost:5500" ||
-		origin == "https://bodha-zeta.vercel.app" {
-		w.Header().Set("Access-Control-Allow-Origin", origin)
+// writeErrorReply writes a failed ChatReply with status, or with 200 when
+// ERROR_STYLE=envelope, for frontends that only look at the error field.
+func writeErrorReply(w http.ResponseWriter, r *http.Request, status int, out ChatReply) {
+	if envString("ERROR_STYLE", "http") == "envelope" {
+		status = http.StatusOK
 	}
+	writeJSON(w, r, status, out)
+}
+
+// wantRaw reports whether the client opted into the raw upstream response,
+// via the body flag or ?raw=true.
+func wantRaw(r *http.Request, flag bool) bool {
+	raw, _ := strconv.ParseBool(r.URL.Query().Get("raw"))
+	return flag || raw
+}
+
+// writeJSON encodes v as the response body. Output is compact unless the
+// caller asks for ?pretty=true or DEBUG_PRETTY_JSON is set.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 
-	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	enc := json.NewEncoder(w)
+	if pretty, _ := strconv.ParseBool(r.URL.Query().Get("pretty")); pretty || envBool("DEBUG_PRETTY_JSON", false) {
+		enc.SetIndent("", "  ")
+	}
+	enc.Encode(v)
 }
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+// newTestServer serves the full handler chain against a fake Cerebras
+// primed with responses. Each test gets an empty session store and fresh
+// quota and breaker state, and discards the turn log.
+func newTestServer(t *testing.T, responses ...cerebrastest.Response) (*httptest.Server, *cerebrastest.Server) {
+	t.Helper()
+	upstream := cerebrastest.NewServer(responses...)
+	t.Cleanup(upstream.Close)
+	t.Setenv("CEREBRAS_BASE_URL", upstream.URL)
+	t.Setenv("CEREBRAS_API_KEY", "test-key")
+
+	setVar(t, &store, SessionStore(newMemoryStore(0)))
+	setVar(t, &quota, newDailyQuota(0, time.Now))
+	setVar(t, &breaker, newCircuitBreaker(0, time.Minute, time.Minute, time.Now))
+	setVar(t, &logger, slog.New(slog.NewJSONHandler(io.Discard, nil)))
+
+	srv := httptest.NewServer(newHandler())
+	t.Cleanup(srv.Close)
+	return srv, upstream
+}
+
+// setVar sets a package variable for the duration of the test.
+func setVar[T any](t *testing.T, p *T, v T) {
+	t.Helper()
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+// do sends body, if not nil, as JSON and returns the response with its
+// body read.
+func do(t *testing.T, method, url string, body interface{}) (*http.Response, []byte) {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return send(t, req)
+}
+
+// send performs req and returns the response with its body read.
+func send(t *testing.T, req *http.Request) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, data
+}
+
+// sentMessages returns the messages of an upstream call.
+func sentMessages(t *testing.T, req cerebrastest.Request) []Message {
+	t.Helper()
+	data, err := json.Marshal(req.Body["messages"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msgs []Message
+	if err := json.Unmarshal(data, &msgs); err != nil {
+		t.Fatal(err)
+	}
+	return msgs
+}
+
+// waitFor polls cond until it holds, failing the test after a second.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestChatCollapseInflight(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "First.", Delay: 200 * time.Millisecond})
+	t.Setenv("COLLAPSE_INFLIGHT", "true")
+	post := func(message string) (*http.Response, []byte) {
+		req, _ := http.NewRequest("POST", srv.URL+"/api/chat/stream", strings.NewReader(`{"session_id": "s1", "message": "`+message+`"}`))
+		return send(t, req)
+	}
+
+	first := make(chan int)
+	go func() {
+		resp, err := http.Post(srv.URL+"/api/chat/stream", "application/json",
+			strings.NewReader(`{"session_id": "s1", "message": "one"}`))
+		if err != nil {
+			first <- 0
+			return
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		first <- resp.StatusCode
+	}()
+	waitFor(t, "the first turn to reach upstream", func() bool { return len(upstream.Requests()) == 1 })
+
+	resp, body := post("two")
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("second request: status = %d, want 409", resp.StatusCode)
+	}
+	if !strings.Contains(string(body), "request in progress") {
+		t.Errorf("second request: body = %s", body)
+	}
+	if status := <-first; status != http.StatusOK {
+		t.Errorf("first request: status = %d, want 200", status)
+	}
+	if n := len(upstream.Requests()); n != 1 {
+		t.Errorf("upstream got %d calls, want 1", n)
+	}
+
+	// once the first turn is done the session takes turns again
+	if resp, _ := post("three"); resp.StatusCode != http.StatusOK {
+		t.Errorf("later request: status = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestPrettyJSON(t *testing.T) {
+	srv, _ := newTestServer(t)
+	post := func(query string) string {
+		req, _ := http.NewRequest("POST", srv.URL+"/api/chat"+query, strings.NewReader(`{}`))
+		_, body := send(t, req)
+		return string(body)
+	}
+
+	if compact := post(""); strings.Contains(compact, "\n  ") {
+		t.Errorf("default output is indented:\n%s", compact)
+	}
+	if pretty := post("?pretty=true"); !strings.Contains(pretty, "{\n  \"reply\": \"\",\n  \"error\": \"is required\",\n  \"field\": \"message\"") {
+		t.Errorf("?pretty=true output isn't indented:\n%s", pretty)
+	}
+
+	t.Setenv("DEBUG_PRETTY_JSON", "true")
+	if body := post(""); !strings.Contains(body, "\n  ") {
+		t.Errorf("DEBUG_PRETTY_JSON output isn't indented:\n%s", body)
+	}
+}
+
+func TestReplyRoleFromUpstream(t *testing.T) {
+	// an empty role falls back to assistant
+	srv, _ := newTestServer(t,
+		cerebrastest.Response{Role: "bodha", Content: "Beep."},
+		cerebrastest.Response{Body: `{"choices": [{"message": {"role": "", "content": "Boop."}}]}`},
+	)
+
+	for _, want := range []string{"bodha", "assistant"} {
+		if resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "roles", Message: "hi"}); resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+		}
+		msgs := history(t, srv.URL, "roles")
+		if got := msgs[len(msgs)-1].Role; got != want {
+			t.Errorf("stored reply role = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestNoSystem(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Plain."})
+	req := ChatRequest{SessionID: "bare", Message: "hi", NoSystem: true}
+
+	resp, _ := do(t, "POST", srv.URL+"/api/chat", req)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("without ALLOW_NO_SYSTEM: status = %d, want 400", resp.StatusCode)
+	}
+	if len(upstream.Requests()) != 0 {
+		t.Error("rejected request reached upstream")
+	}
+
+	t.Setenv("ALLOW_NO_SYSTEM", "true")
+	if resp, body := do(t, "POST", srv.URL+"/api/chat", req); resp.StatusCode != http.StatusOK {
+		t.Fatalf("with ALLOW_NO_SYSTEM: status = %d, body %s", resp.StatusCode, body)
+	}
+	for _, m := range sentMessages(t, upstream.LastRequest()) {
+		if m.Role == "system" {
+			t.Errorf("payload has a system message: %q", m.Content)
+		}
+	}
+}
+
+func TestWhitespaceMessage(t *testing.T) {
+	srv, upstream := newTestServer(t)
+
+	resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "blank", Message: " \t\n "})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", resp.StatusCode)
+	}
+	var reply ChatReply
+	json.Unmarshal(body, &reply)
+	if reply.Field != "message" || reply.Error != "is required" {
+		t.Errorf("reply = %+v, want message is required", reply)
+	}
+	if n := len(upstream.Requests()); n != 0 {
+		t.Errorf("blank message reached upstream %d times", n)
+	}
+}
+
+func TestRawResponse(t *testing.T) {
+	srv, _ := newTestServer(t, cerebrastest.Response{
+		Content: "Raw.",
+		Created: 1700000000,
+		Usage:   &cerebrastest.Usage{PromptTokens: 3, CompletionTokens: 1, TotalTokens: 4},
+	})
+
+	_, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "raw", Message: "hi"})
+	if strings.Contains(string(body), `"raw"`) {
+		t.Errorf("raw included without asking: %s", body)
+	}
+
+	for _, url := range []string{srv.URL + "/api/chat", srv.URL + "/api/chat?raw=true"} {
+		_, body := do(t, "POST", url, ChatRequest{SessionID: "raw", Message: "hi", Raw: !strings.Contains(url, "raw=")})
+		var reply struct {
+			Reply string
+			Raw   struct {
+				ID      string `json:"id"`
+				Object  string `json:"object"`
+				Created int64  `json:"created"`
+				Model   string `json:"model"`
+				Choices []struct {
+					Message Message `json:"message"`
+				} `json:"choices"`
+				Usage Usage `json:"usage"`
+			}
+		}
+		if err := json.Unmarshal(body, &reply); err != nil {
+			t.Fatalf("%s: %v", url, err)
+		}
+		raw := reply.Raw
+		if raw.ID != "chatcmpl-test" || raw.Object != "chat.completion" || raw.Created != 1700000000 || raw.Model != "gpt-oss-120b" {
+			t.Errorf("%s: raw = %+v", url, raw)
+		}
+		if len(raw.Choices) != 1 || raw.Choices[0].Message.Content != "Raw." || raw.Usage.TotalTokens != 4 {
+			t.Errorf("%s: raw choices = %+v, usage = %+v", url, raw.Choices, raw.Usage)
+		}
+	}
+}
+
+func TestRetryOnEmpty(t *testing.T) {
+	srv, upstream := newTestServer(t,
+		cerebrastest.Response{Content: " "},
+		cerebrastest.Response{Content: "Second try."},
+	)
+	t.Setenv("RETRY_ON_EMPTY", "true")
+
+	_, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "empty", Message: "hi"})
+	var reply ChatReply
+	json.Unmarshal(body, &reply)
+	if reply.Reply != "Second try." {
+		t.Errorf("reply = %q, want the retried one", reply.Reply)
+	}
+	if n := len(upstream.Requests()); n != 2 {
+		t.Errorf("upstream got %d calls, want 2", n)
+	}
+
+	// still empty after the retry: fallback text, nothing stored
+	upstream.Enqueue(cerebrastest.Response{Content: ""})
+	t.Setenv("EMPTY_REPLY_FALLBACK", "Nothing here.")
+	_, body = do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "empty2", Message: "hi"})
+	json.Unmarshal(body, &reply)
+	if reply.Reply != "Nothing here." {
+		t.Errorf("reply = %q, want the fallback", reply.Reply)
+	}
+	for _, m := range history(t, srv.URL, "empty2") {
+		if m.Role != "user" {
+			t.Errorf("stored %+v after an empty reply", m)
+		}
+	}
+
+	// streams can't retry, but don't store the empty turn either
+	_, body = do(t, "POST", srv.URL+"/api/chat/stream", ChatRequest{SessionID: "empty3", Message: "hi"})
+	events := parseSSE(string(body))
+	if last := events[len(events)-1]; last.name != "done" || !strings.Contains(last.data, "Nothing here.") {
+		t.Errorf("stream ended with %+v, want done with the fallback", last)
+	}
+	for _, m := range history(t, srv.URL, "empty3") {
+		if m.Role != "user" {
+			t.Errorf("stream stored %+v after an empty reply", m)
+		}
+	}
+}
+
+func TestMaxRequestDuration(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Too late.", Delay: 2 * time.Second})
+	t.Setenv("MAX_REQUEST_DURATION", "200ms")
+
+	start := time.Now()
+	resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "slow", Message: "hi"})
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want 504; body %s", resp.StatusCode, body)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("took %s, want the deadline to cut the call short", d)
+	}
+
+	// retries count against the deadline too; the full schedule would
+	// take 2.75s
+	t.Setenv("UPSTREAM_RETRIES", "10")
+	t.Setenv("UPSTREAM_RETRY_BACKOFF", "50ms")
+	upstream.Enqueue(cerebrastest.Response{Status: http.StatusServiceUnavailable, Error: "overloaded"})
+	calls := len(upstream.Requests())
+	start = time.Now()
+	if resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "slow", Message: "again"}); resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("retrying: status = %d, want 504; body %s", resp.StatusCode, body)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("retrying took %s, want the deadline to cut the retries short", d)
+	}
+	if n := len(upstream.Requests()) - calls; n >= 11 {
+		t.Errorf("upstream got %d calls, want the retries cut short", n)
+	}
+
+	// a stream out of time keeps what it has
+	upstream.Enqueue(cerebrastest.Response{Stream: []string{"Part ", "one ", "two ", "three"}, ChunkDelay: 100 * time.Millisecond})
+	_, body = do(t, "POST", srv.URL+"/api/chat/stream", ChatRequest{SessionID: "slow", Message: "go"})
+	events := parseSSE(string(body))
+	if len(events) < 2 || events[len(events)-2].name != "timeout" {
+		t.Fatalf("stream didn't end with a timeout event:\n%s", body)
+	}
+	var done ChatReply
+	json.Unmarshal([]byte(events[len(events)-1].data), &done)
+	if done.Reply == "" || done.Reply == "Part one two three" || !done.Truncated {
+		t.Errorf("done = %+v, want the partial reply flagged truncated", done)
+	}
+}
+
+func TestFallbackReply(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Status: http.StatusInternalServerError, Error: "down"})
+	t.Setenv("UPSTREAM_RETRIES", "2")
+	t.Setenv("UPSTREAM_RETRY_BACKOFF", "1ms")
+
+	if resp, _ := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "down", Message: "hi"}); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("without USE_FALLBACK_REPLY: status = %d, want the error", resp.StatusCode)
+	}
+
+	t.Setenv("USE_FALLBACK_REPLY", "true")
+	t.Setenv("FALLBACK_REPLY", "Back soon.")
+	calls := len(upstream.Requests())
+	resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "down", Message: "hi"})
+	var reply ChatReply
+	json.Unmarshal(body, &reply)
+	if resp.StatusCode != http.StatusOK || reply.Reply != "Back soon." || !reply.Fallback {
+		t.Errorf("status = %d, reply = %+v, want the flagged fallback", resp.StatusCode, reply)
+	}
+	if n := len(upstream.Requests()) - calls; n != 3 {
+		t.Errorf("upstream got %d calls, want every retry tried first", n)
+	}
+	_, body = do(t, "GET", srv.URL+"/api/history?session_id=down", nil)
+	if strings.Contains(string(body), "Back soon.") {
+		t.Errorf("fallback stored in history: %s", body)
+	}
+}
+
+func TestShutdownDrainsThenFlushes(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Last words.", Delay: 100 * time.Millisecond})
+
+	done := make(chan int)
+	go func() {
+		resp, err := http.Post(srv.URL+"/api/chat", "application/json",
+			strings.NewReader(`{"session_id": "leaving", "message": "hi"}`))
+		if err != nil {
+			done <- 0
+			return
+		}
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+	waitFor(t, "the turn to reach upstream", func() bool { return len(upstream.Requests()) == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var flushed, drained bool
+	shutdown(ctx, srv.Config, func(flushCtx context.Context) error {
+		flushed = true
+		// the handler stores the reply just before it returns
+		if sess, ok, _ := findSession("leaving"); ok && sess.mu.TryLock() {
+			last, _ := sess.history.last()
+			drained = last.Content == "Last words."
+			sess.mu.Unlock()
+		}
+		if _, ok := flushCtx.Deadline(); !ok {
+			t.Error("tracing flushed without the shutdown deadline")
+		}
+		return nil
+	})
+	if !flushed || !drained {
+		t.Errorf("flushed = %v, drained first = %v, want traces flushed after in-flight requests", flushed, drained)
+	}
+	if status := <-done; status != http.StatusOK {
+		t.Errorf("in-flight turn: status = %d, want 200", status)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	srv, upstream := newTestServer(t)
+
+	for path, tc := range map[string]struct{ method, allow string }{
+		"/api/chat":        {"GET", "POST"},
+		"/api/chat/stream": {"DELETE", "GET, HEAD, POST"},
+		"/api/history":     {"POST", "GET, HEAD"},
+		"/api/message/m1":  {"GET", "DELETE"},
+		"/api/session":     {"POST", "DELETE"},
+	} {
+		resp, _ := do(t, tc.method, srv.URL+path, nil)
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s: status = %d, want 405", tc.method, path, resp.StatusCode)
+		}
+		if got := resp.Header.Get("Allow"); got != tc.allow {
+			t.Errorf("%s %s: Allow = %q, want %q", tc.method, path, got, tc.allow)
+		}
+	}
+	if n := len(upstream.Requests()); n != 0 {
+		t.Errorf("wrong methods reached upstream %d times", n)
+	}
+}
+
+func TestCreatedTimestamp(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Dated.", Created: 1700000000})
+
+	_, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "dated", Message: "hi"})
+	var reply ChatReply
+	json.Unmarshal(body, &reply)
+	if reply.Created != 1700000000 {
+		t.Errorf("created = %d, want upstream's", reply.Created)
+	}
+
+	upstream.Enqueue(cerebrastest.Response{Content: "Undated."})
+	before := time.Now().Unix()
+	_, body = do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "dated", Message: "hi"})
+	json.Unmarshal(body, &reply)
+	if reply.Created < before || reply.Created > time.Now().Unix() {
+		t.Errorf("created = %d without an upstream value, want now", reply.Created)
+	}
+}
+
+func TestErrorStyle(t *testing.T) {
+	srv, _ := newTestServer(t, cerebrastest.Response{Status: http.StatusTooManyRequests, Error: "slow down"})
+
+	for style, statuses := range map[string][2]int{
+		"http":     {http.StatusBadRequest, http.StatusInternalServerError},
+		"envelope": {http.StatusOK, http.StatusOK},
+	} {
+		t.Setenv("ERROR_STYLE", style)
+		for i, req := range []ChatRequest{
+			{SessionID: "style", Message: ""},
+			{SessionID: "style", Message: "hi"},
+		} {
+			resp, body := do(t, "POST", srv.URL+"/api/chat", req)
+			var reply ChatReply
+			json.Unmarshal(body, &reply)
+			if resp.StatusCode != statuses[i] || reply.Error == "" {
+				t.Errorf("%s, request %d: status = %d, reply = %+v, want %d with an error", style, i, resp.StatusCode, reply, statuses[i])
+			}
+		}
+	}
+}
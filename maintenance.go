@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// maintenance is the global kill switch for chat traffic. It starts from
+// MAINTENANCE_MODE and can be flipped at runtime via /admin/maintenance.
+var maintenance atomic.Bool
+
+func init() {
+	maintenance.Store(envBool("MAINTENANCE_MODE", false))
+}
+
+type maintenanceState struct {
+	Enabled bool `json:"enabled"`
+}
+
+// withMaintenance answers 503 instead of calling next while maintenance
+// mode is on.
+func withMaintenance(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if maintenance.Load() {
+			w.Header().Set("Retry-After", "300")
+			writeErrorStatus(w, r, http.StatusServiceUnavailable, "Bodha is down for maintenance. Try again later.")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleMaintenance reports (GET) or sets (POST {"enabled": bool}) the
+// maintenance flag.
+func handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var state maintenanceState
+		if err := decodeJSON(r, &state); err != nil {
+			writeErrorStatus(w, r, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+			return
+		}
+		maintenance.Store(state.Enabled)
+		logger.Info("maintenance mode changed", "enabled", state.Enabled)
+	}
+	writeJSON(w, r, http.StatusOK, maintenanceState{Enabled: maintenance.Load()})
+}
+
+type healthStatus struct {
+	Status string `json:"status"`
+	BuildInfo
+}
+
+// handleHealth is the liveness probe. It stays up during maintenance.
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, healthStatus{Status: "ok", BuildInfo: buildInfo()})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	srv, _ := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	t.Cleanup(func() { maintenance.Store(false) })
+
+	toggle := func(on bool) {
+		t.Helper()
+		body := `{"enabled": false}`
+		if on {
+			body = `{"enabled": true}`
+		}
+		req, _ := http.NewRequest("POST", srv.URL+"/admin/maintenance", strings.NewReader(body))
+		req.Header.Set("X-Admin-Token", "admin-secret")
+		if resp, data := send(t, req); resp.StatusCode != http.StatusOK {
+			t.Fatalf("toggle: status = %d, body %s", resp.StatusCode, data)
+		}
+	}
+	chat := func() int {
+		resp, _ := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "maint", Message: "hi"})
+		return resp.StatusCode
+	}
+
+	toggle(true)
+	if status := chat(); status != http.StatusServiceUnavailable {
+		t.Errorf("chat in maintenance: status = %d, want 503", status)
+	}
+	if resp, _ := do(t, "GET", srv.URL+"/health", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("health in maintenance: status = %d, want 200", resp.StatusCode)
+	}
+
+	toggle(false)
+	if status := chat(); status != http.StatusOK {
+		t.Errorf("chat after maintenance: status = %d, want 200", status)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	conversationTurns = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "cerebraschat_conversation_turns",
+		Help:    "User turns in a conversation when it is reset, deleted or expires.",
+		Buckets: []float64{1, 2, 3, 5, 8, 13, 21, 34},
+	})
+	autoTrims = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cerebraschat_auto_trims_total",
+		Help: "Times the oldest message was evicted from a full history ring.",
+	})
+	tokensConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cerebraschat_tokens_total",
+		Help: "Tokens consumed upstream since startup, by type (prompt or completion).",
+	}, []string{"type"})
+	streamTTFT = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "cerebraschat_stream_ttft_seconds",
+		Help:    "Time from a stream request arriving to its first model token.",
+		Buckets: envFloatList("TTFT_BUCKETS", []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10}),
+	})
+)
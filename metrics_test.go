@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+// histogramState reads h's current sample count, sum and cumulative
+// bucket counts by upper bound.
+func histogramState(t *testing.T, h prometheus.Histogram) (uint64, float64, map[float64]uint64) {
+	t.Helper()
+	var m dto.Metric
+	if err := h.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	buckets := map[float64]uint64{}
+	for _, b := range m.Histogram.Bucket {
+		buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+	}
+	return m.Histogram.GetSampleCount(), m.Histogram.GetSampleSum(), buckets
+}
+
+func TestConversationLengthMetrics(t *testing.T) {
+	srv, _ := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+	t.Setenv("RING_CAPACITY", "4")
+
+	count0, sum0, buckets0 := histogramState(t, conversationTurns)
+	trims0 := testutil.ToFloat64(autoTrims)
+
+	for _, turns := range []int{1, 2, 5} {
+		id := fmt.Sprintf("len-%d", turns)
+		for i := 0; i < turns; i++ {
+			if resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: id, Message: "hi"}); resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+			}
+		}
+	}
+	// 10 messages through a ring of 4 evict 6
+	if got := testutil.ToFloat64(autoTrims) - trims0; got != 6 {
+		t.Errorf("auto trims grew by %v, want 6", got)
+	}
+
+	// the 5-turn conversation ends by reset, the others by deletion;
+	// evicted turns count towards the length either way
+	if resp, _ := do(t, "POST", srv.URL+"/api/reset", CancelRequest{SessionID: "len-5"}); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("reset: status = %d", resp.StatusCode)
+	}
+	for _, id := range []string{"len-1", "len-2"} {
+		if resp, _ := do(t, "DELETE", srv.URL+"/api/session?session_id="+id, nil); resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("delete %s: status = %d", id, resp.StatusCode)
+		}
+	}
+	count, sum, buckets := histogramState(t, conversationTurns)
+	if got := count - count0; got != 3 {
+		t.Errorf("observed %d conversations, want 3", got)
+	}
+	if got := sum - sum0; got != 8 {
+		t.Errorf("observed %v turns in total, want 8", got)
+	}
+	for bound, want := range map[float64]uint64{1: 1, 2: 2, 3: 2, 5: 3} {
+		if got := buckets[bound] - buckets0[bound]; got != want {
+			t.Errorf("bucket le=%v grew by %d, want %d", bound, got, want)
+		}
+	}
+
+	resp, body := do(t, "GET", srv.URL+"/metrics", nil)
+	if resp.StatusCode != http.StatusOK || !bytes.Contains(body, []byte("cerebraschat_conversation_turns_bucket")) {
+		t.Errorf("/metrics doesn't expose the histogram: status %d", resp.StatusCode)
+	}
+}
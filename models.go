@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// modelProfile describes what a model accepts.
+type modelProfile struct {
+	// AssistantPrefix is true when the model continues a trailing partial
+	// assistant message instead of starting a fresh reply.
+	AssistantPrefix bool
+	// TopKMinP is true when the model accepts the top_k and min_p
+	// sampling parameters.
+	TopKMinP bool
+	// MaxTokens is the largest max_tokens the model accepts; 0 means
+	// unknown, so nothing is clamped.
+	MaxTokens int
+}
+
+// modelProfiles are the known models. MODEL_MAX_TOKENS=model=n,... overrides
+// their token limits or adds limits for other models.
+var modelProfiles = withMaxTokenOverrides(map[string]modelProfile{
+	"gpt-oss-120b": {AssistantPrefix: true, TopKMinP: true, MaxTokens: 65536},
+	"zai-glm-4.7":  {AssistantPrefix: true, TopKMinP: true, MaxTokens: 40960},
+}, envList("MODEL_MAX_TOKENS", nil))
+
+func withMaxTokenOverrides(profiles map[string]modelProfile, pairs []string) map[string]modelProfile {
+	for _, pair := range pairs {
+		model, v, ok := strings.Cut(pair, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if !ok || err != nil || n <= 0 {
+			logger.Warn("ignoring invalid model max tokens", "entry", pair)
+			continue
+		}
+		model = strings.TrimSpace(model)
+		p := profiles[model]
+		p.MaxTokens = n
+		profiles[model] = p
+	}
+	return profiles
+}
+
+// clampMaxTokens caps params.MaxTokens at the model's limit so an
+// over-large request is trimmed instead of rejected upstream.
+func clampMaxTokens(params GenParams) GenParams {
+	limit := modelProfiles[params.Model].MaxTokens
+	if limit > 0 && params.MaxTokens > limit {
+		logger.Info("clamping max_tokens to model limit", "model", params.Model, "requested", params.MaxTokens, "limit", limit)
+		params.MaxTokens = limit
+	}
+	return params
+}
+
+// withAssistantPrefix returns msgs with a partial assistant message
+// appended for the model to continue from. msgs is not modified.
+func withAssistantPrefix(msgs []Message, prefix string) []Message {
+	if prefix == "" {
+		return msgs
+	}
+	out := make([]Message, len(msgs), len(msgs)+1)
+	copy(out, msgs)
+	return append(out, Message{Role: "assistant", Content: prefix})
+}
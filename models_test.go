@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestAssistantPrefix(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: " the answer is 4."})
+
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "primed", Message: "2+2?"})
+	sent := sentMessages(t, upstream.LastRequest())
+	if last := sent[len(sent)-1]; last.Role != "user" {
+		t.Errorf("payload without a prefix ends with %+v", last)
+	}
+
+	_, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "primed", Message: "again?", AssistantPrefix: "Well,"})
+	sent = sentMessages(t, upstream.LastRequest())
+	if last := sent[len(sent)-1]; last.Role != "assistant" || last.Content != "Well," {
+		t.Errorf("payload ends with %+v, want the partial assistant message", last)
+	}
+	var reply ChatReply
+	json.Unmarshal(body, &reply)
+	if reply.Reply != "Well, the answer is 4." {
+		t.Errorf("reply = %q, want it to start with the prefix", reply.Reply)
+	}
+
+	withPersona(t, "plain", Persona{SystemPrompt: "Be plain.", Params: GenParams{Model: "plain-model"}})
+	calls := len(upstream.Requests())
+	resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "plain", Persona: "plain", Message: "hi", AssistantPrefix: "Well,"})
+	json.Unmarshal(body, &reply)
+	if resp.StatusCode != http.StatusBadRequest || reply.Field != "assistant_prefix" {
+		t.Errorf("unsupported model: status = %d, reply = %+v", resp.StatusCode, reply)
+	}
+	if len(upstream.Requests()) != calls {
+		t.Error("rejected prefix reached upstream")
+	}
+	if msgs := history(t, srv.URL, "plain"); len(msgs) != 0 {
+		t.Errorf("rejected turn stored %+v", msgs)
+	}
+}
+
+func TestMaxTokensClamped(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+	logs := captureLogs(t)
+
+	for _, tc := range []struct{ asked, sent int }{
+		{100000, 65536},
+		{1000, 1000},
+	} {
+		do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "big", Message: "hi", GenParams: GenParams{MaxTokens: tc.asked}})
+		if got := upstream.LastRequest().Body["max_tokens"]; got != float64(tc.sent) {
+			t.Errorf("asked for %d: sent max_tokens = %v, want %d", tc.asked, got, tc.sent)
+		}
+	}
+	if recs := logs.records(t, "clamping max_tokens to model limit"); len(recs) != 1 || recs[0]["requested"] != 100000.0 {
+		t.Errorf("clamp log records = %v, want one for the over-large request", recs)
+	}
+
+	profiles := withMaxTokenOverrides(map[string]modelProfile{"a": {MaxTokens: 10}}, []string{"a=20", "b = 30", "c=x"})
+	if profiles["a"].MaxTokens != 20 || profiles["b"].MaxTokens != 30 || len(profiles) != 2 {
+		t.Errorf("overridden profiles = %+v", profiles)
+	}
+}
+
+func TestTopKMinP(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+	topK, minP := 40, 0.05
+
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "sampling", Message: "hi"})
+	for _, key := range []string{"top_k", "min_p"} {
+		if v, ok := upstream.LastRequest().Body[key]; ok {
+			t.Errorf("%s sent without being set: %v", key, v)
+		}
+	}
+
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "sampling", Message: "hi", GenParams: GenParams{TopK: &topK, MinP: &minP}})
+	if body := upstream.LastRequest().Body; body["top_k"] != 40.0 || body["min_p"] != 0.05 {
+		t.Errorf("top_k = %v, min_p = %v, want 40 and 0.05", body["top_k"], body["min_p"])
+	}
+
+	// dropped for models that don't take them
+	withPersona(t, "plain", Persona{SystemPrompt: "Be plain.", Params: GenParams{Model: "plain-model"}})
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "plain", Persona: "plain", Message: "hi", GenParams: GenParams{TopK: &topK, MinP: &minP}})
+	for _, key := range []string{"top_k", "min_p"} {
+		if v, ok := upstream.LastRequest().Body[key]; ok {
+			t.Errorf("%s sent to a model without support: %v", key, v)
+		}
+	}
+
+	for _, p := range []GenParams{{TopK: new(int)}, {MinP: float64Ptr(-0.1)}} {
+		if resp, _ := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "sampling", Message: "hi", GenParams: p}); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("out of range %+v: status = %d, want 400", p, resp.StatusCode)
+		}
+	}
+}
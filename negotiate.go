@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Representations /api/chat can answer with, picked from the Accept header.
+const (
+	mediaJSON   = "application/json"
+	mediaText   = "text/plain"
+	mediaStream = "text/event-stream"
+)
+
+// negotiate picks the representation the client prefers by Accept q-value,
+// defaulting to JSON when nothing supported is asked for.
+func negotiate(r *http.Request) string {
+	best, bestQ := mediaJSON, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		switch mt {
+		case mediaJSON, mediaText, mediaStream:
+		case "*/*", "application/*":
+			mt = mediaJSON
+		case "text/*":
+			mt = mediaText
+		default:
+			continue
+		}
+		if q > bestQ {
+			best, bestQ = mt, q
+		}
+	}
+	return best
+}
+
+// writeReply writes a successful turn as JSON, or as just the reply text
+// when the client negotiated text/plain.
+func writeReply(w http.ResponseWriter, r *http.Request, out ChatReply) {
+	if negotiate(r) != mediaText {
+		writeJSON(w, r, http.StatusOK, out)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, out.Reply)
+}
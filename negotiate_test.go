@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestAcceptNegotiation(t *testing.T) {
+	srv, _ := newTestServer(t, cerebrastest.Response{Content: "Negotiated.", Stream: []string{"Negoti", "ated."}})
+
+	for _, tc := range []struct {
+		accept, contentType string
+		check               func(body string) bool
+	}{
+		{"", "application/json", func(body string) bool {
+			var reply ChatReply
+			return json.Unmarshal([]byte(body), &reply) == nil && reply.Reply == "Negotiated."
+		}},
+		{"application/json", "application/json", func(body string) bool {
+			return strings.Contains(body, `"reply":"Negotiated."`)
+		}},
+		{"text/plain", "text/plain", func(body string) bool { return body == "Negotiated." }},
+		{"text/event-stream", "text/event-stream", func(body string) bool {
+			events := parseSSE(body)
+			return len(events) > 0 && events[len(events)-1].name == "done"
+		}},
+		{"text/plain;q=0.5, text/event-stream;q=0.9", "text/event-stream", func(body string) bool {
+			return strings.Contains(body, "event: done")
+		}},
+		{"image/png", "application/json", func(body string) bool {
+			return strings.Contains(body, `"reply"`)
+		}},
+	} {
+		req, _ := http.NewRequest("POST", srv.URL+"/api/chat", bytes.NewReader([]byte(`{"session_id": "neg", "message": "hi"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		resp, body := send(t, req)
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, tc.contentType) {
+			t.Errorf("Accept %q: content type = %q, want %s", tc.accept, ct, tc.contentType)
+		}
+		if !tc.check(string(body)) {
+			t.Errorf("Accept %q: unexpected body:\n%s", tc.accept, body)
+		}
+	}
+}
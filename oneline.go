@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const oneLineReminder = "Your last reply broke the rules. Reply again in EXACTLY ONE line. No line breaks."
+
+func isMultiline(s string) bool {
+	return strings.Contains(strings.TrimSpace(s), "\n")
+}
+
+// firstLine returns the first non-empty line of s.
+func firstLine(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// retryOneLine re-issues the turn once, showing the model its rejected
+// reply followed by a one-line reminder (neither is stored in the
+// session). If the retry fails or is still multi-line, the original reply
+// is cut to its first line as a last resort.
+func retryOneLine(ctx context.Context, msgs []Message, params GenParams, reply string) string {
+	retry := append(append([]Message(nil), msgs...),
+		Message{Role: "assistant", Content: reply},
+		Message{Role: "user", Content: oneLineReminder},
+	)
+
+	apiRes, err := complete(ctx, retry, params)
+	if err == nil {
+		if again := apiRes.Choices[0].Message.Content; !isMultiline(again) && strings.TrimSpace(again) != "" {
+			return strings.TrimSpace(again)
+		}
+	}
+	return firstLine(reply)
+}
+
+const fullerReminder = "Your last reply was too short. Reply again with a slightly fuller answer, still in ONE line."
+
+func replyLen(s string) int {
+	return utf8.RuneCountInString(strings.TrimSpace(s))
+}
+
+// retryFuller re-issues the turn once for replies under MIN_REPLY_CHARS,
+// showing the model its short reply followed by a nudge (neither is stored
+// in the session). The longer of the two replies wins.
+func retryFuller(ctx context.Context, msgs []Message, params GenParams, reply string) string {
+	retry := append(append([]Message(nil), msgs...),
+		Message{Role: "assistant", Content: reply},
+		Message{Role: "user", Content: fullerReminder},
+	)
+
+	apiRes, err := complete(ctx, retry, params)
+	if err == nil {
+		if again := apiRes.Choices[0].Message.Content; replyLen(again) > replyLen(reply) {
+			return strings.TrimSpace(again)
+		}
+	}
+	return reply
+}
+
+var explainTriggers = envList("EXPLAIN_TRIGGERS", []string{"explain", "why", "how", "details"})
+
+// asksForExplanation reports whether msg contains one of EXPLAIN_TRIGGERS
+// as a word.
+func asksForExplanation(msg string) bool {
+	words := strings.FieldsFunc(strings.ToLower(msg), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		for _, t := range explainTriggers {
+			if w == strings.ToLower(t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// firstSentence returns s up to and including its first sentence-ending
+// punctuation followed by a space, or all of s if there is none.
+func firstSentence(s string) string {
+	s = strings.TrimSpace(s)
+	for i, r := range s {
+		if r != '.' && r != '!' && r != '?' {
+			continue
+		}
+		if next := s[i+1:]; next == "" || next[0] == ' ' || next[0] == '\n' {
+			return s[:i+1]
+		}
+	}
+	return s
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestOneLineRetry(t *testing.T) {
+	srv, upstream := newTestServer(t,
+		cerebrastest.Response{Content: "Line one.\nLine two."},
+		cerebrastest.Response{Content: "Just one line."},
+	)
+	t.Setenv("ENFORCE_ONELINE_RETRY", "true")
+
+	resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "oneline", Message: "hi"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+	}
+	var reply ChatReply
+	json.Unmarshal(body, &reply)
+	if reply.Reply != "Just one line." {
+		t.Errorf("reply = %q, want the single-line retry", reply.Reply)
+	}
+
+	reqs := upstream.Requests()
+	if len(reqs) != 2 {
+		t.Fatalf("upstream got %d calls, want 2", len(reqs))
+	}
+	// the retry shows the model its rejected reply, then the reminder
+	sent := sentMessages(t, reqs[1])
+	n := len(sent)
+	if n < 2 || sent[n-2] != (Message{Role: "assistant", Content: "Line one.\nLine two."}) || sent[n-1] != (Message{Role: "user", Content: oneLineReminder}) {
+		t.Errorf("retry payload ends with %+v, want the rejected reply and then the reminder", sent)
+	}
+}
+
+func TestOneLineRetryFallsBackToFirstLine(t *testing.T) {
+	srv, _ := newTestServer(t, cerebrastest.Response{Content: "Line one.\nLine two."})
+	t.Setenv("ENFORCE_ONELINE_RETRY", "true")
+
+	_, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "oneline", Message: "hi"})
+	var reply ChatReply
+	json.Unmarshal(body, &reply)
+	if reply.Reply != "Line one." {
+		t.Errorf("reply = %q, want the first line once the retry is multi-line too", reply.Reply)
+	}
+}
+
+func TestMinReplyChars(t *testing.T) {
+	srv, upstream := newTestServer(t,
+		cerebrastest.Response{Content: "No."},
+		cerebrastest.Response{Content: "No, and asking twice won't help."},
+	)
+	t.Setenv("MIN_REPLY_CHARS", "10")
+
+	_, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "terse", Message: "can I?"})
+	var reply ChatReply
+	json.Unmarshal(body, &reply)
+	if reply.Reply != "No, and asking twice won't help." {
+		t.Errorf("reply = %q, want the fuller retry", reply.Reply)
+	}
+	reqs := upstream.Requests()
+	if len(reqs) != 2 {
+		t.Fatalf("upstream got %d calls, want 2", len(reqs))
+	}
+	// the retry shows the model its short reply, then the nudge
+	sent := sentMessages(t, reqs[1])
+	n := len(sent)
+	if n < 2 || sent[n-2] != (Message{Role: "assistant", Content: "No."}) || sent[n-1] != (Message{Role: "user", Content: fullerReminder}) {
+		t.Errorf("retry payload ends with %+v, want the short reply and then the nudge", sent)
+	}
+	for _, m := range history(t, srv.URL, "terse") {
+		if strings.Contains(m.Content, fullerReminder) {
+			t.Error("nudge stored in history")
+		}
+	}
+
+	// long enough the first time: no retry
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "terse", Message: "again?"})
+	if n := len(upstream.Requests()); n != 3 {
+		t.Errorf("upstream got %d calls, want no retry for a long enough reply", n)
+	}
+}
+
+func TestNoExplainFilter(t *testing.T) {
+	long := "Paris. It has been the capital since 987. Also, the food is great."
+	srv, _ := newTestServer(t, cerebrastest.Response{Content: long})
+
+	reply := func(msg string) string {
+		_, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "brief", Message: msg})
+		var r ChatReply
+		json.Unmarshal(body, &r)
+		return r.Reply
+	}
+
+	if got := reply("Capital of France?"); got != long {
+		t.Errorf("filter off: reply = %q", got)
+	}
+	t.Setenv("NO_EXPLAIN_FILTER", "true")
+	if got := reply("Capital of France?"); got != "Paris." {
+		t.Errorf("filter on: reply = %q, want the first sentence", got)
+	}
+	if got := reply("Why is Paris the capital of France?"); got != long {
+		t.Errorf("asked why: reply = %q, want it whole", got)
+	}
+	// triggers are whole words
+	if got := reply("Showhow: capital of France?"); got != "Paris." {
+		t.Errorf("trigger inside a word: reply = %q, want the first sentence", got)
+	}
+
+	setVar(t, &explainTriggers, []string{"elaborate"})
+	if got := reply("Please elaborate on France's capital."); got != long {
+		t.Errorf("custom trigger: reply = %q, want it whole", got)
+	}
+	if got := reply("Why Paris?"); got != "Paris." {
+		t.Errorf("replaced trigger list still honours why: %q", got)
+	}
+}
+
+func TestFirstSentence(t *testing.T) {
+	for in, want := range map[string]string{
+		"Yes. And more.":          "Yes.",
+		"Version 1.2 is out! Go.": "Version 1.2 is out!",
+		"Really?\nYes.":           "Really?",
+		"No punctuation":          "No punctuation",
+		"  Trailing dot.  ":       "Trailing dot.",
+	} {
+		if got := firstSentence(in); got != want {
+			t.Errorf("firstSentence(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// renderSystemPrompt expands text/template variables in a persona's
+// system prompt. Client vars are available by name (e.g. {{.UserName}});
+// server-provided values such as {{.Date}} take precedence over them.
+func renderSystemPrompt(prompt string, vars map[string]string) (string, error) {
+	if !strings.Contains(prompt, "{{") {
+		return prompt, nil
+	}
+
+	tmpl, err := template.New("system").Option("missingkey=zero").Parse(prompt)
+	if err != nil {
+		return "", err
+	}
+
+	data := make(map[string]string, len(vars)+1)
+	for k, v := range vars {
+		data[k] = v
+	}
+	data["Date"] = time.Now().UTC().Format("2006-01-02")
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+var supportedLanguages = envList("SUPPORTED_LANGUAGES", []string{
+	"English", "Spanish", "French", "German", "Portuguese", "Hindi", "Malayalam",
+})
+
+// resolveLanguage returns the canonical name of a supported language, or
+// an error listing what is supported.
+func resolveLanguage(lang string) (string, error) {
+	for _, l := range supportedLanguages {
+		if strings.EqualFold(l, lang) {
+			return l, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported language %q (supported: %s)", lang, strings.Join(supportedLanguages, ", "))
+}
+
+// withLanguage returns msgs with a directive to reply in lang appended to
+// the leading system prompt, keeping the persona intact. msgs is not
+// modified.
+func withLanguage(msgs []Message, lang string) []Message {
+	if lang == "" {
+		return msgs
+	}
+
+	directive := fmt.Sprintf("LANGUAGE:\n- Always reply in %s, whatever language the user writes in.", lang)
+	out := append([]Message(nil), msgs...)
+	if len(out) > 0 && out[0].Role == "system" {
+		out[0].Content = strings.TrimRight(out[0].Content, "\n\t ") + "\n\n" + directive
+		return out
+	}
+	return append([]Message{{Role: "system", Content: directive}}, out...)
+}
+
+// singleSystem collapses every system message in msgs into one at index 0,
+// keeping the rest in order. SYSTEM_MESSAGE_MERGE picks how: "concat"
+// (default) joins them in order, "last" keeps only the final one. msgs is
+// not modified.
+func singleSystem(msgs []Message) []Message {
+	var system []string
+	rest := make([]Message, 0, len(msgs))
+	for _, m := range msgs {
+		if m.Role == "system" {
+			system = append(system, m.Content)
+			continue
+		}
+		rest = append(rest, m)
+	}
+	if len(system) == 0 {
+		return msgs
+	}
+	if len(system) == 1 && msgs[0].Role == "system" {
+		return msgs
+	}
+
+	content := system[len(system)-1]
+	if envString("SYSTEM_MESSAGE_MERGE", "concat") != "last" {
+		parts := make([]string, len(system))
+		for i, s := range system {
+			parts[i] = strings.TrimSpace(s)
+		}
+		content = strings.Join(parts, "\n\n")
+	}
+	return append([]Message{{Role: "system", Content: content}}, rest...)
+}
+
+// systemHash returns a short hash of the leading system prompt in msgs, so
+// clients caching a persona can tell when it changed. Empty without one.
+func systemHash(msgs []Message) string {
+	if len(msgs) == 0 || msgs[0].Role != "system" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(msgs[0].Content))
+	return hex.EncodeToString(sum[:6])
+}
+
+// mergeConsecutive joins runs of same-role messages into one, separated by
+// MERGE_SEPARATOR, for models that require alternating roles. msgs is not
+// modified.
+func mergeConsecutive(msgs []Message) []Message {
+	sep := envString("MERGE_SEPARATOR", "\n\n")
+	out := make([]Message, 0, len(msgs))
+	for _, m := range msgs {
+		if n := len(out); n > 0 && out[n-1].Role == m.Role {
+			out[n-1].Content += sep + m.Content
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+// withPersona registers p under name for the duration of the test.
+func withPersona(t *testing.T, name string, p Persona) {
+	t.Helper()
+	all := maps.Clone(personas)
+	all[name] = p
+	setVar(t, &personas, all)
+}
+
+func TestSystemPromptTemplate(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Hello."})
+	withPersona(t, "host", Persona{SystemPrompt: "You are talking to {{.UserName}} on {{.Date}}."})
+
+	resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{
+		SessionID: "templated",
+		Persona:   "host",
+		Vars:      map[string]string{"UserName": "Asha", "Date": "spoofed"},
+		Message:   "hi",
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+	}
+	want := "You are talking to Asha on " + time.Now().UTC().Format("2006-01-02") + "."
+	if got := sentMessages(t, upstream.LastRequest())[0]; got.Role != "system" || got.Content != want {
+		t.Errorf("system message = %+v, want %q", got, want)
+	}
+
+	// vars only seed the session; later turns keep the rendered prompt
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "templated", Vars: map[string]string{"UserName": "Ravi"}, Message: "again"})
+	if got := sentMessages(t, upstream.LastRequest())[0].Content; got != want {
+		t.Errorf("second turn system message = %q, want %q", got, want)
+	}
+}
+
+func TestLanguageDirective(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Hola."})
+
+	resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "lang", Message: "hi", Language: "spanish"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+	}
+	system := sentMessages(t, upstream.LastRequest())[0].Content
+	if !strings.HasPrefix(system, strings.TrimRight(BODHA_ROAST_SYSTEM_PROMPT, "\n\t ")) {
+		t.Error("persona prompt lost")
+	}
+	if !strings.HasSuffix(system, "Always reply in Spanish, whatever language the user writes in.") {
+		t.Errorf("system prompt lacks the language directive:\n%s", system)
+	}
+
+	resp, _ = do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "lang", Message: "hi", Language: "Klingon"})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unsupported language: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestSingleSystem(t *testing.T) {
+	msgs := []Message{
+		{Role: "system", Content: "Be brief. "},
+		{Role: "user", Content: "hi"},
+		{Role: "system", Content: "Be kind."},
+		{Role: "assistant", Content: "hello"},
+	}
+	for mode, want := range map[string]string{
+		"concat": "Be brief.\n\nBe kind.|hi|hello",
+		"last":   "Be kind.|hi|hello",
+	} {
+		t.Setenv("SYSTEM_MESSAGE_MERGE", mode)
+		got := singleSystem(msgs)
+		if strings.Join(contents(got), "|") != want || got[0].Role != "system" || got[1].Role != "user" {
+			t.Errorf("%s: got %+v", mode, got)
+		}
+	}
+	if msgs[2].Content != "Be kind." {
+		t.Error("input modified")
+	}
+
+	// context docs are a second system message behind the persona's
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Hot."})
+	t.Setenv("SYSTEM_MESSAGE_MERGE", "last")
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "merged", Message: "hi", Context: []string{"Water boils at 100C."}})
+	sent := sentMessages(t, upstream.LastRequest())
+	var systems int
+	for _, m := range sent {
+		if m.Role == "system" {
+			systems++
+		}
+	}
+	if systems != 1 || !strings.HasPrefix(sent[0].Content, "Use the following context") {
+		t.Errorf("payload = %+v, want only the last system message at index 0", sent)
+	}
+}
+
+func TestSystemHash(t *testing.T) {
+	srv, _ := newTestServer(t, cerebrastest.Response{Content: "Ok.", Stream: []string{"Ok."}})
+	withPersona(t, "other", Persona{SystemPrompt: "You are someone else."})
+
+	hash := func(path string, req ChatRequest) string {
+		_, body := do(t, "POST", srv.URL+path, req)
+		if path == "/api/chat/stream" {
+			events := parseSSE(string(body))
+			body = []byte(events[len(events)-1].data)
+		}
+		var reply ChatReply
+		json.Unmarshal(body, &reply)
+		return reply.SystemHash
+	}
+
+	first := hash("/api/chat", ChatRequest{SessionID: "h1", Message: "one"})
+	if len(first) != 12 {
+		t.Fatalf("system_hash = %q, want 12 hex characters", first)
+	}
+	for _, got := range []string{
+		hash("/api/chat", ChatRequest{SessionID: "h1", Message: "two"}),
+		hash("/api/chat/stream", ChatRequest{SessionID: "h1", Message: "three"}),
+		hash("/api/chat", ChatRequest{SessionID: "h2", Message: "one"}),
+	} {
+		if got != first {
+			t.Errorf("system_hash = %q, want %q for the same persona", got, first)
+		}
+	}
+	if got := hash("/api/chat", ChatRequest{SessionID: "h3", Persona: "other", Message: "one"}); got == first || got == "" {
+		t.Errorf("system_hash = %q for another persona, want a different one", got)
+	}
+}
+
+func TestMergeConsecutiveRoles(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+	req := StatelessRequest{Messages: []Message{
+		{Role: "user", Content: "a"},
+		{Role: "user", Content: "b"},
+		{Role: "assistant", Content: "c"},
+		{Role: "assistant", Content: "d"},
+		{Role: "user", Content: "e"},
+	}}
+	sent := func() string {
+		var out []string
+		for _, m := range sentMessages(t, upstream.LastRequest())[1:] {
+			out = append(out, m.Role+":"+m.Content)
+		}
+		return strings.Join(out, "|")
+	}
+
+	do(t, "POST", srv.URL+"/api/chat/stateless", req)
+	if got := sent(); got != "user:a|user:b|assistant:c|assistant:d|user:e" {
+		t.Errorf("by default: sent %q", got)
+	}
+
+	t.Setenv("MERGE_CONSECUTIVE_ROLES", "true")
+	do(t, "POST", srv.URL+"/api/chat/stateless", req)
+	if got := sent(); got != "user:a\n\nb|assistant:c\n\nd|user:e" {
+		t.Errorf("merged: sent %q", got)
+	}
+	t.Setenv("MERGE_SEPARATOR", " / ")
+	do(t, "POST", srv.URL+"/api/chat/stateless", req)
+	if got := sent(); got != "user:a / b|assistant:c / d|user:e" {
+		t.Errorf("with MERGE_SEPARATOR: sent %q", got)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// dailyQuota caps requests per client IP per UTC day. now is injectable so
+// the midnight rollover can be exercised without waiting for it.
+type dailyQuota struct {
+	mu     sync.Mutex
+	limit  int
+	now    func() time.Time
+	day    time.Time
+	counts map[string]int
+}
+
+func newDailyQuota(limit int, now func() time.Time) *dailyQuota {
+	return &dailyQuota{limit: limit, now: now, counts: map[string]int{}}
+}
+
+var quota = newDailyQuota(envInt("DAILY_QUOTA", 0), time.Now)
+
+// allow counts one request for ip and reports whether it is within the
+// quota, along with when the quota next resets. A limit <= 0 disables it.
+func (q *dailyQuota) allow(ip string) (bool, time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now().UTC()
+	today := now.Truncate(24 * time.Hour)
+	resetAt := today.Add(24 * time.Hour)
+	if q.limit <= 0 {
+		return true, resetAt
+	}
+
+	// a new UTC day wipes every counter
+	if !today.Equal(q.day) {
+		q.day = today
+		q.counts = map[string]int{}
+	}
+
+	if q.counts[ip] >= q.limit {
+		return false, resetAt
+	}
+	q.counts[ip]++
+	return true, resetAt
+}
+
+// withDailyQuota rejects requests with 429 once the client IP has used up
+// DAILY_QUOTA for the current UTC day. Priority requests aren't counted.
+func withDailyQuota(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isPriority(r) {
+			next(w, r)
+			return
+		}
+		ok, resetAt := quota.allow(clientIP(r))
+		if !ok {
+			retry := int(resetAt.Sub(quota.now()).Seconds()) + 1
+			w.Header().Set("Retry-After", strconv.Itoa(retry))
+			writeErrorStatus(w, r, http.StatusTooManyRequests,
+				fmt.Sprintf("Daily quota exceeded. It resets at %s.", resetAt.Format(time.RFC3339)))
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+// fakeClock is a settable time source.
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func TestDailyQuota(t *testing.T) {
+	srv, _ := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+	clock := &fakeClock{t: time.Date(2026, 3, 1, 22, 0, 0, 0, time.UTC)}
+	setVar(t, &quota, newDailyQuota(2, clock.now))
+
+	chat := func() (*http.Response, []byte) {
+		return do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "quota", Message: "hi"})
+	}
+	for i := 0; i < 2; i++ {
+		if resp, _ := chat(); resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: status = %d", i+1, resp.StatusCode)
+		}
+	}
+	resp, body := chat()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("over quota: status = %d, want 429", resp.StatusCode)
+	}
+	if !strings.Contains(string(body), "resets at 2026-03-02T00:00:00Z") {
+		t.Errorf("body = %s, want the reset time", body)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "7201" {
+		t.Errorf("Retry-After = %q, want 7201", got)
+	}
+
+	// still the same UTC day
+	clock.advance(time.Hour + 59*time.Minute)
+	if resp, _ := chat(); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("before midnight: status = %d, want 429", resp.StatusCode)
+	}
+
+	clock.advance(2 * time.Minute)
+	if resp, _ := chat(); resp.StatusCode != http.StatusOK {
+		t.Errorf("after midnight: status = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestDailyQuotaPerIP(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	q := newDailyQuota(1, clock.now)
+
+	if ok, _ := q.allow("10.0.0.1"); !ok {
+		t.Error("first request from 10.0.0.1 rejected")
+	}
+	if ok, _ := q.allow("10.0.0.1"); ok {
+		t.Error("second request from 10.0.0.1 allowed")
+	}
+	if ok, _ := q.allow("10.0.0.2"); !ok {
+		t.Error("another IP shares the first one's quota")
+	}
+}
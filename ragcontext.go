@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// validateContextDocs enforces MAX_CONTEXT_CHARS across all snippets.
+func validateContextDocs(docs []string) error {
+	total := 0
+	for _, d := range docs {
+		total += len(d)
+	}
+	if max := envInt("MAX_CONTEXT_CHARS", 8000); total > max {
+		return fmt.Errorf("context too large: %d chars (max %d)", total, max)
+	}
+	return nil
+}
+
+// withContextDocs returns msgs with the snippets formatted into one extra
+// system message right after the leading system prompt. msgs is not
+// modified, so the snippets only ever reach this turn's payload.
+func withContextDocs(msgs []Message, docs []string) []Message {
+	if len(docs) == 0 {
+		return msgs
+	}
+
+	var b strings.Builder
+	b.WriteString("Use the following context to answer if it is relevant.\n")
+	for i, d := range docs {
+		fmt.Fprintf(&b, "\n[%d] %s\n", i+1, strings.TrimSpace(d))
+	}
+	ctxMsg := Message{Role: "system", Content: b.String()}
+
+	at := 0
+	if len(msgs) > 0 && msgs[0].Role == "system" {
+		at = 1
+	}
+	out := make([]Message, 0, len(msgs)+1)
+	out = append(out, msgs[:at]...)
+	out = append(out, ctxMsg)
+	return append(out, msgs[at:]...)
+}
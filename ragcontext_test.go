@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestContextDocsThisTurnOnly(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+
+	resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{
+		SessionID: "rag",
+		Message:   "what's the refund window?",
+		Context:   []string{"Refunds are accepted within 30 days.", "Shipping takes 5 days."},
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+	}
+	sent := sentMessages(t, upstream.LastRequest())
+	system := sent[0].Content
+	for _, snippet := range []string{"[1] Refunds are accepted within 30 days.", "[2] Shipping takes 5 days."} {
+		if !strings.Contains(system, snippet) {
+			t.Errorf("payload lacks %q:\n%s", snippet, system)
+		}
+	}
+
+	for _, m := range history(t, srv.URL, "rag") {
+		if strings.Contains(m.Content, "Refunds") {
+			t.Errorf("context stored in history: %+v", m)
+		}
+	}
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "rag", Message: "thanks"})
+	if payload, _ := json.Marshal(upstream.LastRequest().Body); strings.Contains(string(payload), "Refunds") {
+		t.Error("context resent on the next turn")
+	}
+
+	t.Setenv("MAX_CONTEXT_CHARS", "10")
+	resp, _ = do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "rag", Message: "hi", Context: []string{"far too much context"}})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("oversized context: status = %d, want 400", resp.StatusCode)
+	}
+}
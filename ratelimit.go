@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// rateLimitWindows is the order upstream windows are preferred in when it
+// reports several for the same limit. The shortest window is the one a
+// client runs into first, so it comes first by default.
+var rateLimitWindows = envList("RATELIMIT_WINDOWS", []string{"minute", "hour", "day"})
+
+// upstreamRateLimits normalizes Cerebras rate-limit headers, which carry
+// a window suffix (e.g. x-ratelimit-remaining-tokens-minute), into the
+// X-RateLimit-{Limit,Remaining,Reset}-{Requests,Tokens} set we expose.
+// An unsuffixed header wins, then the first of rateLimitWindows present.
+func upstreamRateLimits(h http.Header) http.Header {
+	out := http.Header{}
+	for _, field := range []string{"limit", "remaining", "reset"} {
+		for _, kind := range []string{"requests", "tokens"} {
+			prefix := "x-ratelimit-" + field + "-" + kind
+			value := h.Get(prefix)
+			for _, window := range rateLimitWindows {
+				if value != "" {
+					break
+				}
+				value = h.Get(prefix + "-" + window)
+			}
+			if value != "" {
+				out.Set("X-RateLimit-"+title(field)+"-"+title(kind), value)
+			}
+		}
+	}
+	return out
+}
+
+func title(s string) string {
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// upstreamError is a completion upstream refused. It keeps the rate-limit
+// headers of the refusal, which matter most on a 429.
+type upstreamError struct {
+	err        error
+	rateLimits http.Header
+}
+
+func (e *upstreamError) Error() string { return e.err.Error() }
+func (e *upstreamError) Unwrap() error { return e.err }
+
+// rateLimitsOf returns the rate-limit headers carried by err, if any.
+func rateLimitsOf(err error) http.Header {
+	var ue *upstreamError
+	if errors.As(err, &ue) {
+		return ue.rateLimits
+	}
+	return nil
+}
+
+// forwardRateLimits copies the normalized upstream limits onto w so the
+// frontend can back off before hitting them. Streams send their headers
+// before upstream answers, so only the JSON routes carry them.
+func forwardRateLimits(w http.ResponseWriter, limits http.Header) {
+	for name, values := range limits {
+		w.Header()[name] = values
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestRateLimitHeadersForwarded(t *testing.T) {
+	srv, _ := newTestServer(t, cerebrastest.Response{
+		Content: "Ok.",
+		Headers: map[string]string{
+			"x-ratelimit-limit-requests-day":      "14400",
+			"x-ratelimit-remaining-requests-day":  "14000",
+			"x-ratelimit-limit-tokens-minute":     "60000",
+			"x-ratelimit-remaining-tokens-minute": "59000",
+			"x-ratelimit-remaining-tokens-hour":   "900000",
+			"x-ratelimit-reset-tokens-minute":     "12.5",
+		},
+	})
+
+	req, _ := http.NewRequest("POST", srv.URL+"/api/chat", strings.NewReader(`{"session_id": "rl", "message": "hi"}`))
+	req.Header.Set("Origin", "https://dibinxavier.github.io")
+	resp, body := send(t, req)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+	}
+	for header, want := range map[string]string{
+		"X-RateLimit-Limit-Requests":     "14400",
+		"X-RateLimit-Remaining-Requests": "14000",
+		"X-RateLimit-Limit-Tokens":       "60000",
+		"X-RateLimit-Remaining-Tokens":   "59000", // minute beats hour
+		"X-RateLimit-Reset-Tokens":       "12.5",
+		"X-RateLimit-Reset-Requests":     "",
+	} {
+		if got := resp.Header.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+	if exposed := resp.Header.Get("Access-Control-Expose-Headers"); !strings.Contains(exposed, "X-RateLimit-Remaining-Tokens") {
+		t.Errorf("rate-limit headers not exposed to browsers: %q", exposed)
+	}
+}
+
+func TestRateLimitHeadersOnError(t *testing.T) {
+	srv, _ := newTestServer(t, cerebrastest.Response{
+		Status:  http.StatusTooManyRequests,
+		Error:   "slow down",
+		Headers: map[string]string{"x-ratelimit-remaining-requests-minute": "0", "x-ratelimit-reset-requests-minute": "30"},
+	})
+
+	for path, body := range map[string]interface{}{
+		"/api/chat":           ChatRequest{SessionID: "rl", Message: "hi"},
+		"/api/chat/stateless": StatelessRequest{Messages: []Message{{Role: "user", Content: "hi"}}},
+	} {
+		resp, _ := do(t, "POST", srv.URL+path, body)
+		if resp.StatusCode == http.StatusOK {
+			t.Fatalf("%s: status = 200, want the upstream failure", path)
+		}
+		if got := resp.Header.Get("X-RateLimit-Remaining-Requests"); got != "0" {
+			t.Errorf("%s: X-RateLimit-Remaining-Requests = %q, want 0", path, got)
+		}
+		if got := resp.Header.Get("X-RateLimit-Reset-Requests"); got != "30" {
+			t.Errorf("%s: X-RateLimit-Reset-Requests = %q, want 30", path, got)
+		}
+	}
+}
+
+func TestRateLimitWindowOrder(t *testing.T) {
+	h := http.Header{}
+	h.Set("x-ratelimit-remaining-tokens-day", "3")
+	h.Set("x-ratelimit-remaining-tokens-hour", "2")
+	h.Set("x-ratelimit-remaining-tokens-minute", "1")
+
+	for i := 0; i < 20; i++ {
+		if got := upstreamRateLimits(h).Get("X-RateLimit-Remaining-Tokens"); got != "1" {
+			t.Fatalf("picked %q, want the minute window every time", got)
+		}
+	}
+	h.Set("x-ratelimit-remaining-tokens", "0")
+	if got := upstreamRateLimits(h).Get("X-RateLimit-Remaining-Tokens"); got != "0" {
+		t.Errorf("picked %q, want the unsuffixed header", got)
+	}
+}
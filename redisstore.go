@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisKeyPrefix = "cerebraschat:session:"
+
+// sessionState is the JSON form of a session kept in Redis. The persona
+// itself isn't stored; it is looked up by name on load.
+type sessionState struct {
+	Persona    string    `json:"persona"`
+	System     string    `json:"system,omitempty"`
+	Model      string    `json:"model,omitempty"`
+	Messages   []Message `json:"messages"`
+	UserTurns  int       `json:"user_turns"`
+	LastID     int       `json:"last_id"`
+	LastActive time.Time `json:"last_active"`
+	Title      string    `json:"title,omitempty"`
+	TitleTurns int       `json:"title_turns,omitempty"`
+}
+
+// state snapshots s for storage. Callers must hold s.mu.
+func (s *session) state() sessionState {
+	return sessionState{
+		Persona:    s.personaName,
+		System:     s.system,
+		Model:      s.model,
+		Messages:   s.history.slice(),
+		UserTurns:  s.userTurns,
+		LastID:     s.lastID,
+		LastActive: s.lastActive,
+		Title:      s.title,
+		TitleTurns: s.titleTurns,
+	}
+}
+
+// load replaces s's contents with st. Callers must hold s.mu for writing.
+func (s *session) load(st sessionState) {
+	s.personaName = st.Persona
+	s.persona = personas[st.Persona]
+	s.system = st.System
+	s.model = st.Model
+	s.history = newRing(envInt("RING_CAPACITY", 10))
+	for _, m := range st.Messages {
+		s.history.push(m)
+	}
+	s.userTurns = st.UserTurns
+	s.lastID = st.LastID
+	s.lastActive = st.LastActive
+	s.title, s.titleTurns = st.Title, st.TitleTurns
+}
+
+// redisStore keeps sessions in Redis as JSON under redisKeyPrefix+id, each
+// expiring SESSION_TTL after its last save, so several instances behind a
+// load balancer share them. Sessions are also cached locally so that
+// requests on this instance share one *session and its lock; Get refreshes
+// the cached copy from Redis unless a turn is holding it. Concurrent turns
+// on the same session on different instances aren't serialized: the last
+// save wins.
+type redisStore struct {
+	client *redis.Client
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	local map[string]*session
+}
+
+func newRedisStore(client *redis.Client, ttl time.Duration) *redisStore {
+	return &redisStore{client: client, ttl: ttl, local: map[string]*session{}}
+}
+
+func (r *redisStore) Get(id string) (*session, bool, error) {
+	raw, err := r.client.Get(context.Background(), redisKeyPrefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		r.mu.Lock()
+		delete(r.local, id) // expired or deleted elsewhere
+		r.mu.Unlock()
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var st sessionState
+	if err := json.Unmarshal(raw, &st); err != nil {
+		return nil, false, fmt.Errorf("decoding session %q: %w", id, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.local[id]
+	if !ok {
+		s = &session{}
+		s.load(st)
+		r.local[id] = s
+	} else if s.mu.TryLock() {
+		s.load(st)
+		s.mu.Unlock()
+	}
+	return s, true, nil
+}
+
+func (r *redisStore) Save(id string, s *session) error {
+	raw, err := json.Marshal(s.state())
+	if err != nil {
+		return err
+	}
+	if err := r.client.Set(context.Background(), redisKeyPrefix+id, raw, r.ttl).Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.local[id] = s
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *redisStore) Delete(id string) error {
+	if err := r.client.Del(context.Background(), redisKeyPrefix+id).Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	delete(r.local, id)
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *redisStore) List() ([]string, error) {
+	var ids []string
+	iter := r.client.Scan(context.Background(), 0, redisKeyPrefix+"*", 100).Iterator()
+	for iter.Next(context.Background()) {
+		ids = append(ids, strings.TrimPrefix(iter.Val(), redisKeyPrefix))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// newSessionStore returns the store named by the environment: Redis when
+// REDIS_URL is set, memory otherwise. An unreachable Redis is an error
+// unless REDIS_FALLBACK=memory, in which case the instance runs on its own
+// sessions and says so in the log. SESSION_TTL bounds how long an idle
+// session lives: 24h by default in Redis, forever in memory unless set.
+func newSessionStore(ctx context.Context) (SessionStore, error) {
+	rawURL := envString("REDIS_URL", "")
+	if rawURL == "" {
+		return newMemoryStore(envDuration("SESSION_TTL", 0)), nil
+	}
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("REDIS_URL: %w", err)
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		if envString("REDIS_FALLBACK", "") == "memory" {
+			logger.Warn("redis unreachable, using in-memory sessions",
+				"redis", redactURL(rawURL), "error", err)
+			return newMemoryStore(envDuration("SESSION_TTL", 0)), nil
+		}
+		return nil, fmt.Errorf("redis %s: %w", redactURL(rawURL), err)
+	}
+	return newRedisStore(client, envDuration("SESSION_TTL", 24*time.Hour)), nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestRedis returns a redisStore on a fresh in-process Redis.
+func newTestRedis(t *testing.T, ttl time.Duration) (*redisStore, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return newRedisStore(client, ttl), mr
+}
+
+func TestRedisStore(t *testing.T) {
+	st, _ := newTestRedis(t, time.Hour)
+	testSessionStore(t, st)
+}
+
+func TestRedisStoreTTL(t *testing.T) {
+	st, mr := newTestRedis(t, time.Hour)
+	st.Save("a", storedSession(t, "hi"))
+
+	if ttl := mr.TTL(redisKeyPrefix + "a"); ttl != time.Hour {
+		t.Errorf("key TTL = %s, want SESSION_TTL", ttl)
+	}
+	mr.FastForward(59 * time.Minute)
+	s, _, _ := st.Get("a")
+	st.Save("a", s)
+	mr.FastForward(59 * time.Minute)
+	if _, ok, _ := st.Get("a"); !ok {
+		t.Error("session expired although saved within the TTL")
+	}
+	mr.FastForward(2 * time.Minute)
+	if _, ok, _ := st.Get("a"); ok {
+		t.Error("idle session outlived its TTL")
+	}
+}
+
+func TestRedisStoreShared(t *testing.T) {
+	one, mr := newTestRedis(t, time.Hour)
+	two := newRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), time.Hour)
+	defer two.client.Close()
+
+	one.Save("shared", storedSession(t, "from one"))
+	s, ok, err := two.Get("shared")
+	if !ok || err != nil {
+		t.Fatalf("other instance: ok = %v, err = %v", ok, err)
+	}
+	s.mu.Lock()
+	s.append(Message{Role: "assistant", Content: "from two"})
+	two.Save("shared", s)
+	s.mu.Unlock()
+
+	s, _, _ = one.Get("shared")
+	if got := strings.Join(contents(s.history.slice()), "|"); got != "from one|from two" {
+		t.Errorf("first instance sees %q, want the other's change", got)
+	}
+
+	two.Delete("shared")
+	if _, ok, _ := one.Get("shared"); ok {
+		t.Error("session deleted on one instance still found on the other")
+	}
+}
+
+func TestNewSessionStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+
+	st, err := newSessionStore(ctx)
+	if _, ok := st.(*memoryStore); !ok || err != nil {
+		t.Errorf("without REDIS_URL: %T, %v, want the memory store", st, err)
+	}
+
+	t.Setenv("REDIS_URL", "redis://"+mr.Addr())
+	st, err = newSessionStore(ctx)
+	if _, ok := st.(*redisStore); !ok || err != nil {
+		t.Fatalf("with REDIS_URL: %T, %v, want the redis store", st, err)
+	}
+	st.(*redisStore).client.Close()
+
+	t.Setenv("REDIS_URL", "redis://:hunter2@"+mr.Addr())
+	mr.Close()
+	st, err = newSessionStore(ctx)
+	if err == nil || st != nil {
+		t.Errorf("unreachable redis: %T, %v, want an error", st, err)
+	} else if strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("error leaks the password: %v", err)
+	}
+
+	t.Setenv("REDIS_FALLBACK", "memory")
+	st, err = newSessionStore(ctx)
+	if _, ok := st.(*memoryStore); !ok || err != nil {
+		t.Errorf("unreachable redis with REDIS_FALLBACK=memory: %T, %v", st, err)
+	}
+
+	t.Setenv("REDIS_URL", "not a url")
+	if _, err := newSessionStore(ctx); err == nil {
+		t.Error("invalid REDIS_URL accepted")
+	}
+}
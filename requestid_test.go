@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestRequestIDHeader(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+
+	ok, _ := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "rid", Message: "hi"})
+	failed, _ := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "rid"})
+	if ok.StatusCode != http.StatusOK || failed.StatusCode != http.StatusBadRequest {
+		t.Fatalf("statuses = %d, %d", ok.StatusCode, failed.StatusCode)
+	}
+	for name, resp := range map[string]*http.Response{"success": ok, "error": failed} {
+		if id := resp.Header.Get("X-Request-ID"); !validRequestID(id) {
+			t.Errorf("%s: X-Request-ID = %q", name, id)
+		}
+	}
+	if ok.Header.Get("X-Request-ID") == failed.Header.Get("X-Request-ID") {
+		t.Error("two requests got the same generated ID")
+	}
+	if got := upstream.LastRequest().Header.Get("X-Request-ID"); got != ok.Header.Get("X-Request-ID") {
+		t.Errorf("upstream saw request ID %q", got)
+	}
+
+	// a sane inbound ID is echoed, anything else replaced
+	for inbound, echoed := range map[string]bool{"support-1234": true, "bad id!": false} {
+		req, _ := http.NewRequest("GET", srv.URL+"/health", nil)
+		req.Header["X-Request-Id"] = []string{inbound}
+		resp, _ := send(t, req)
+		if got := resp.Header.Get("X-Request-ID"); (got == inbound) != echoed || got == "" {
+			t.Errorf("inbound %q: X-Request-ID = %q", inbound, got)
+		}
+	}
+
+	// browsers may send their own ID only if the preflight allows it
+	req, _ := http.NewRequest("OPTIONS", srv.URL+"/api/chat", nil)
+	req.Header.Set("Origin", "https://dibinxavier.github.io")
+	req.Header.Set("Access-Control-Request-Headers", "x-request-id")
+	resp, _ := send(t, req)
+	if got := resp.Header.Get("Access-Control-Allow-Headers"); !strings.Contains(got, "X-Request-ID") {
+		t.Errorf("preflight Allow-Headers = %q, want X-Request-ID", got)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// ResponseFormat is passed through to Cerebras as response_format, e.g.
+// {"type": "json_object"} for JSON mode.
+type ResponseFormat struct {
+	Type string `json:"type"`
+}
+
+// jsonMode reports whether params ask for a JSON object reply. Such
+// replies are data, so the persona's text filters must leave them alone.
+func jsonMode(params GenParams) bool {
+	return params.ResponseFormat != nil && params.ResponseFormat.Type == "json_object"
+}
+
+// checkJSONReply verifies a JSON-mode reply actually parses, unless
+// VALIDATE_JSON_REPLY is turned off. Other formats aren't checked.
+func checkJSONReply(params GenParams, reply string) error {
+	if !jsonMode(params) {
+		return nil
+	}
+	if !envBool("VALIDATE_JSON_REPLY", true) || json.Valid([]byte(reply)) {
+		return nil
+	}
+	return errors.New("model returned invalid JSON in json_object mode")
+}
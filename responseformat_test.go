@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestResponseFormat(t *testing.T) {
+	obj := "{\n  \"answer\": 4,\n  \"why\": \"2+2. Simple.\"\n}"
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: obj})
+	t.Setenv("ENFORCE_ONELINE_RETRY", "true")
+	t.Setenv("NO_EXPLAIN_FILTER", "true")
+	jsonParams := GenParams{ResponseFormat: &ResponseFormat{Type: "json_object"}}
+
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "plain", Message: "2+2?"})
+	if rf, ok := upstream.LastRequest().Body["response_format"]; ok {
+		t.Errorf("response_format sent without asking: %v", rf)
+	}
+
+	calls := len(upstream.Requests())
+	resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "json", Message: "2+2?", GenParams: jsonParams})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+	}
+	if rf, _ := upstream.LastRequest().Body["response_format"].(map[string]interface{}); rf["type"] != "json_object" {
+		t.Errorf("response_format = %v, want json_object forwarded", upstream.LastRequest().Body["response_format"])
+	}
+	var reply ChatReply
+	json.Unmarshal(body, &reply)
+	if reply.Reply != obj {
+		t.Errorf("reply = %q, want the JSON untouched by the text filters", reply.Reply)
+	}
+	if n := len(upstream.Requests()) - calls; n != 1 {
+		t.Errorf("upstream got %d calls, want no one-line retry", n)
+	}
+
+	upstream.Enqueue(cerebrastest.Response{Content: "Sure! {\"answer\": 4"})
+	if resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "json", Message: "again", GenParams: jsonParams}); resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("invalid JSON reply: status = %d, body %s", resp.StatusCode, body)
+	}
+
+	bad := GenParams{ResponseFormat: &ResponseFormat{Type: "xml"}}
+	if resp, _ := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "json", Message: "hi", GenParams: bad}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown format: status = %d, want 400", resp.StatusCode)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+// ring is a fixed-capacity FIFO of messages. Once full, each push
+// overwrites the oldest entry in place, so memory per session is bounded
+// and never reallocated.
+type ring struct {
+	buf   []Message
+	start int
+	n     int
+}
+
+func newRing(capacity int) *ring {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &ring{buf: make([]Message, capacity)}
+}
+
+// push appends m and reports whether the oldest message was evicted.
+func (r *ring) push(m Message) bool {
+	if r.n < len(r.buf) {
+		r.buf[(r.start+r.n)%len(r.buf)] = m
+		r.n++
+		return false
+	}
+	r.buf[r.start] = m
+	r.start = (r.start + 1) % len(r.buf)
+	return true
+}
+
+// slice returns the messages oldest first as a fresh slice.
+func (r *ring) slice() []Message {
+	out := make([]Message, r.n)
+	for i := range out {
+		out[i] = r.buf[(r.start+i)%len(r.buf)]
+	}
+	return out
+}
+
+// last returns the newest message, if any.
+func (r *ring) last() (Message, bool) {
+	if r.n == 0 {
+		return Message{}, false
+	}
+	return r.buf[(r.start+r.n-1)%len(r.buf)], true
+}
+
+// pop removes and returns the newest message, if any.
+func (r *ring) pop() (Message, bool) {
+	m, ok := r.last()
+	if ok {
+		r.buf[(r.start+r.n-1)%len(r.buf)] = Message{}
+		r.n--
+	}
+	return m, ok
+}
+
+func (r *ring) len() int { return r.n }
+
+func (r *ring) clear() {
+	clear(r.buf)
+	r.start, r.n = 0, 0
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func contents(msgs []Message) []string {
+	out := make([]string, len(msgs))
+	for i, m := range msgs {
+		out[i] = m.Content
+	}
+	return out
+}
+
+func TestRingWraps(t *testing.T) {
+	r := newRing(3)
+	for i := 1; i <= 5; i++ {
+		evicted := r.push(Message{Content: fmt.Sprint(i)})
+		if want := i > 3; evicted != want {
+			t.Errorf("push %d: evicted = %v, want %v", i, evicted, want)
+		}
+	}
+	if got := fmt.Sprint(contents(r.slice())); got != "[3 4 5]" {
+		t.Errorf("after wrapping: %s, want [3 4 5]", got)
+	}
+
+	if m, _ := r.pop(); m.Content != "5" {
+		t.Errorf("pop = %q, want 5", m.Content)
+	}
+	r.push(Message{Content: "6"})
+	r.push(Message{Content: "7"})
+	if got := fmt.Sprint(contents(r.slice())); got != "[4 6 7]" {
+		t.Errorf("after pop and push: %s, want [4 6 7]", got)
+	}
+	if m, _ := r.last(); m.Content != "7" || r.len() != 3 {
+		t.Errorf("last = %q, len = %d", m.Content, r.len())
+	}
+
+	r.clear()
+	if _, ok := r.last(); ok || r.len() != 0 || len(r.slice()) != 0 {
+		t.Error("ring not empty after clear")
+	}
+}
+
+func TestSessionRingKeepsSystemPrompt(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+	t.Setenv("RING_CAPACITY", "3")
+
+	for i := 1; i <= 3; i++ {
+		if resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "ring", Message: fmt.Sprint("q", i)}); resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+		}
+	}
+
+	// the third turn sees q2's reply, q3 and nothing older
+	msgs := sentMessages(t, upstream.LastRequest())
+	if msgs[0].Role != "system" || msgs[0].Content != BODHA_ROAST_SYSTEM_PROMPT {
+		t.Errorf("first message = %+v, want the pinned system prompt", msgs[0])
+	}
+	if got := fmt.Sprint(contents(msgs[1:])); got != "[q2 Ok. q3]" {
+		t.Errorf("history sent = %s, want [q2 Ok. q3]", got)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// normalizeMessage is applied to every user message before it is stored:
+// line endings become \n, control characters other than newlines and tabs
+// are dropped, and surrounding whitespace is trimmed.
+func normalizeMessage(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.Map(func(r rune) rune {
+		if r == '\r' {
+			return '\n'
+		}
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return -1
+		}
+		return r
+	}, s)
+	return strings.TrimSpace(s)
+}
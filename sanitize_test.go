@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestEchoMessage(t *testing.T) {
+	srv, _ := newTestServer(t, cerebrastest.Response{Content: "Ok.", Stream: []string{"Ok."}})
+	raw := "  hi\r\nthere\x07\rfriend \t"
+	want := "hi\nthere\nfriend"
+
+	_, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "echo", Message: raw})
+	var reply ChatReply
+	json.Unmarshal(body, &reply)
+	if reply.Message != "" {
+		t.Errorf("message echoed without asking: %q", reply.Message)
+	}
+
+	_, body = do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "echo", Message: raw, EchoMessage: true})
+	json.Unmarshal(body, &reply)
+	if reply.Message != want {
+		t.Errorf("echoed = %q, want %q", reply.Message, want)
+	}
+
+	_, body = do(t, "POST", srv.URL+"/api/chat/stream", ChatRequest{SessionID: "echo", Message: raw, EchoMessage: true})
+	events := parseSSE(string(body))
+	var done ChatReply
+	json.Unmarshal([]byte(events[len(events)-1].data), &done)
+	if done.Message != want {
+		t.Errorf("stream echoed = %q, want %q", done.Message, want)
+	}
+
+	if got := history(t, srv.URL, "echo")[0].Content; got != want {
+		t.Errorf("stored = %q, want the echoed text", got)
+	}
+}
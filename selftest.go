@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// selftest runs one real completion with the default persona and prints
+// the reply and latency, for verifying a deployment's credentials and
+// connectivity without starting the server.
+func selftest(ctx context.Context) error {
+	p := personas[defaultPersona]
+	msgs := []Message{
+		{Role: "system", Content: p.SystemPrompt},
+		{Role: "user", Content: "Self-test: reply with one short line."},
+	}
+
+	start := time.Now()
+	apiRes, err := complete(ctx, msgs, defaultParams.merge(p.Params))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("selftest ok in %s (model %s, %d tokens): %s\n",
+		time.Since(start).Round(time.Millisecond), apiRes.Model, apiRes.Usage.TotalTokens,
+		firstLine(apiRes.Choices[0].Message.Content))
+	return nil
+}
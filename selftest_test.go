@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestSelftest(t *testing.T) {
+	_, upstream := newTestServer(t, cerebrastest.Response{Content: "Still alive."})
+
+	if err := selftest(context.Background()); err != nil {
+		t.Fatalf("selftest against a healthy upstream: %v", err)
+	}
+	sent := sentMessages(t, upstream.LastRequest())
+	if len(sent) != 2 || sent[0].Content != personas[defaultPersona].SystemPrompt || !strings.HasPrefix(sent[1].Content, "Self-test") {
+		t.Errorf("selftest payload = %+v", sent)
+	}
+	if got := upstream.LastRequest().Header.Get("Authorization"); got != "Bearer test-key" {
+		t.Errorf("Authorization = %q", got)
+	}
+
+	upstream.Enqueue(cerebrastest.Response{Status: http.StatusUnauthorized, Error: "bad key"})
+	if err := selftest(context.Background()); err == nil || !strings.Contains(err.Error(), "bad key") {
+		t.Errorf("selftest with a rejected key: err = %v", err)
+	}
+}
+
+func TestRunSelftestMode(t *testing.T) {
+	_, upstream := newTestServer(t, cerebrastest.Response{Content: "Still alive."})
+	t.Setenv("WARMUP_ON_START", "true")
+	// a port nothing listens on, so a started server would show up there
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	t.Setenv("PORT", addr[strings.LastIndex(addr, ":")+1:])
+
+	if code := runSelftestMode(); code != 0 {
+		t.Errorf("healthy upstream: exit code = %d, want 0", code)
+	}
+	if n := len(upstream.Requests()); n != 1 {
+		t.Errorf("upstream got %d calls, want just the selftest", n)
+	}
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Error("selftest mode started the server")
+	}
+
+	upstream.Enqueue(cerebrastest.Response{Status: http.StatusUnauthorized, Error: "bad key"})
+	if code := runSelftestMode(); code != 1 {
+		t.Errorf("rejected key: exit code = %d, want 1", code)
+	}
+
+	t.Setenv("CEREBRAS_API_KEY", "")
+	calls := len(upstream.Requests())
+	if code := runSelftestMode(); code != 1 {
+		t.Errorf("missing key: exit code = %d, want 1", code)
+	}
+	if n := len(upstream.Requests()) - calls; n != 0 {
+		t.Errorf("missing key: upstream got %d calls, want none", n)
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultSessionID = "default"
+
+// session holds one conversation. mu is write-locked for the whole turn
+// (and for any other change to history) so concurrent requests on the same
+// session can't interleave appends; readers such as the history endpoint
+// take the read lock, so they never see a half-applied change but don't
+// block each other.
+type session struct {
+	mu sync.RWMutex
+	// inTurn is set while a turn holds mu, telling a running turn apart
+	// from readers for COLLAPSE_INFLIGHT.
+	inTurn      atomic.Bool
+	personaName string
+	persona     Persona
+	// system is the persona prompt rendered with the vars the session was
+	// seeded with. It is pinned outside history so it is never evicted.
+	// Empty when the session was started with no_system.
+	system string
+	// model is the model the session started with. Unless MODEL_PIN=off,
+	// every turn uses it.
+	model string
+	// history holds the user/assistant turns, capped at RING_CAPACITY.
+	history *ring
+	// userTurns counts every user message ever appended, including ones
+	// since evicted from history.
+	userTurns int
+	// lastID is the sequence number of the most recently issued message ID.
+	lastID int
+	// lastActive is when the session was created or a message was last
+	// appended.
+	lastActive time.Time
+	// deleted is set when the session is removed with DELETE /api/session,
+	// so turns that were waiting on mu don't save it back.
+	deleted bool
+	// title is the last generated conversation title, made when the
+	// session had titleTurns user turns. Empty until one is asked for.
+	title      string
+	titleTurns int
+}
+
+// sessionsMu serializes session creation so two first requests for the
+// same ID can't both seed it.
+var sessionsMu sync.Mutex
+
+// getSession returns the session for req.SessionID, creating it seeded with
+// the requested persona's system prompt (rendered with req.Vars) on first
+// use. An empty id maps to the shared default; seeding fields are ignored
+// for sessions that already exist. In STATELESS_MODE every call gets a
+// fresh session that is never stored, so nothing outlives the request.
+func getSession(req *ChatRequest) (*session, error) {
+	if req.NoSystem && !envBool("ALLOW_NO_SYSTEM", false) {
+		return nil, invalid("no_system", "not allowed on this server")
+	}
+
+	id := sessionKey(req.SessionID)
+	if !validSessionID(id) {
+		return nil, invalid("session_id", "use up to %d letters, digits or dashes", envInt("MAX_SESSION_ID_LEN", 64))
+	}
+	if envBool("STATELESS_MODE", false) {
+		return newSession(req)
+	}
+
+	s, ok, err := store.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errSessionStore, err)
+	}
+	if ok {
+		return s, nil
+	}
+
+	// only creation is serialized; look again in case another request
+	// created the session while we waited
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	s, ok, err = store.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errSessionStore, err)
+	}
+	if ok {
+		return s, nil
+	}
+	if s, err = newSession(req); err != nil {
+		return nil, err
+	}
+	if err := store.Save(id, s); err != nil {
+		return nil, fmt.Errorf("%w: %v", errSessionStore, err)
+	}
+	return s, nil
+}
+
+// newSession builds a session seeded from req's persona, vars and
+// no_system flag.
+func newSession(req *ChatRequest) (*session, error) {
+	personaName := req.Persona
+	if personaName == "" {
+		personaName = defaultPersona
+	}
+	p, ok := personas[personaName]
+	if !ok {
+		return nil, invalid("persona", "unknown persona %q", personaName)
+	}
+	var system string
+	if !req.NoSystem {
+		rendered, err := renderSystemPrompt(p.SystemPrompt, req.Vars)
+		if err != nil {
+			return nil, fmt.Errorf("system prompt template error: %w", err)
+		}
+		system = rendered
+	}
+	return &session{
+		personaName: personaName,
+		persona:     p,
+		system:      system,
+		model:       defaultParams.merge(p.Params).merge(req.GenParams).Model,
+		history:     newRing(envInt("RING_CAPACITY", 10)),
+		lastActive:  time.Now(),
+	}, nil
+}
+
+// sessionKey maps an empty client session ID to the shared default.
+func sessionKey(id string) string {
+	if id == "" {
+		return defaultSessionID
+	}
+	return id
+}
+
+// validSessionID caps client-chosen IDs at MAX_SESSION_ID_LEN and limits
+// them to letters, digits and dashes, so they can't bloat the session map.
+func validSessionID(id string) bool {
+	if id == "" || len(id) > envInt("MAX_SESSION_ID_LEN", 64) {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// findSession returns an existing session without creating one.
+func findSession(id string) (*session, bool, error) {
+	s, ok, err := store.Get(sessionKey(id))
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", errSessionStore, err)
+	}
+	return s, ok, nil
+}
+
+// forTurn resolves the upstream conversation and generation parameters for
+// req's turn. A req.Persona naming a different persona than the session's
+// swaps in its system prompt and defaults for this turn only; the session
+// keeps its own. req.SystemAppend is likewise added for this turn only.
+// The model stays pinned to the session's: a different req.Model is
+// ignored, or rejected with MODEL_PIN=reject. Callers must hold s.mu.
+func (s *session) forTurn(req *ChatRequest) ([]Message, GenParams, error) {
+	persona, system := s.persona, s.system
+	if req.Persona != "" && req.Persona != s.personaName {
+		p, ok := personas[req.Persona]
+		if !ok {
+			return nil, GenParams{}, invalid("persona", "unknown persona %q", req.Persona)
+		}
+		persona = p
+		// no_system sessions stay without one
+		if s.system != "" {
+			rendered, err := renderSystemPrompt(p.SystemPrompt, req.Vars)
+			if err != nil {
+				return nil, GenParams{}, fmt.Errorf("system prompt template error: %w", err)
+			}
+			system = rendered
+		}
+	}
+	if req.SystemAppend != "" {
+		system = strings.TrimSpace(strings.TrimSpace(system) + "\n\n" + req.SystemAppend)
+	}
+
+	params := s.params(persona, req.GenParams)
+	if mode := envString("MODEL_PIN", "keep"); mode != "off" && params.Model != s.model {
+		// switching models mid-conversation confuses the context
+		if mode == "reject" && req.Model != "" {
+			return nil, GenParams{}, invalid("model", "session is pinned to model %q", s.model)
+		}
+		params.Model = s.model
+	}
+	return s.conversation(system, req.HistoryWindow), params, nil
+}
+
+// params resolves the generation parameters for a turn: server defaults,
+// then the persona's defaults, then the annealed temperature (when
+// TEMP_ANNEAL is on), then per-request overrides. Callers must hold s.mu.
+func (s *session) params(persona Persona, over GenParams) GenParams {
+	p := defaultParams.merge(persona.Params)
+	if envBool("TEMP_ANNEAL", false) && s.userTurns > 0 {
+		p.Temperature = float64Ptr(annealedTemperature(s.userTurns - 1))
+	}
+	return p.merge(over)
+}
+
+// annealedTemperature starts at TEMP_ANNEAL_START for the first turn and
+// drops by TEMP_ANNEAL_STEP per turn, never going below TEMP_ANNEAL_FLOOR.
+func annealedTemperature(turn int) float64 {
+	start := envFloat("TEMP_ANNEAL_START", 1.2)
+	floor := envFloat("TEMP_ANNEAL_FLOOR", 0.3)
+	step := envFloat("TEMP_ANNEAL_STEP", 0.1)
+	t := math.Max(floor, start-float64(turn)*step)
+	return math.Round(t*100) / 100
+}
+
+// checkTurn rejects per-turn options the turn's persona or model can't
+// honor. It doesn't depend on history, so handlers call it before storing
+// the user message to keep a rejected turn out of the session. Callers
+// must hold s.mu.
+func (s *session) checkTurn(req *ChatRequest) error {
+	_, params, err := s.forTurn(req)
+	if err != nil {
+		return err
+	}
+	if req.AssistantPrefix != "" && !modelProfiles[params.Model].AssistantPrefix {
+		return invalid("assistant_prefix", "not supported by model %q", params.Model)
+	}
+	return nil
+}
+
+// lockTurn acquires the session for a turn. When COLLAPSE_INFLIGHT is
+// enabled it refuses instead of waiting if another turn is in flight.
+// Readers holding mu don't count as a turn: they only delay the lock.
+// Release with unlockTurn.
+func (s *session) lockTurn() bool {
+	if envBool("COLLAPSE_INFLIGHT", false) {
+		if !s.inTurn.CompareAndSwap(false, true) {
+			return false
+		}
+		s.mu.Lock()
+		return true
+	}
+	s.mu.Lock()
+	s.inTurn.Store(true)
+	return true
+}
+
+// unlockTurn releases the session after lockTurn.
+func (s *session) unlockTurn() {
+	s.inTurn.Store(false)
+	s.mu.Unlock()
+}
+
+// append adds m to the history, overwriting the oldest turn once the ring
+// is full, and returns its ID. Messages without an ID are given the next
+// one in the session's sequence. Callers must hold s.mu.
+func (s *session) append(m Message) string {
+	if m.ID == "" {
+		s.lastID++
+		m.ID = "m" + strconv.Itoa(s.lastID)
+	}
+	if m.Role == "user" {
+		s.userTurns++
+	}
+	s.lastActive = time.Now()
+	if s.history.push(m) {
+		autoTrims.Inc()
+	}
+	return m.ID
+}
+
+// conversation returns the messages to send upstream: system (if any)
+// followed by the last window messages of history (all of it when window
+// is 0), further trimmed to the token budget. Callers must hold s.mu.
+func (s *session) conversation(system string, window int) []Message {
+	history := s.history.slice()
+	if window > 0 && len(history) > window {
+		history = history[len(history)-window:]
+	}
+	history = trimToBudget(system, history)
+	msgs := make([]Message, 0, len(history)+1)
+	if system != "" {
+		msgs = append(msgs, Message{Role: "system", Content: system})
+	}
+	return append(msgs, history...)
+}
+
+// endConversation records the finished conversation's length, counting
+// every user turn, including ones long evicted from the ring. Callers must
+// hold s.mu.
+func (s *session) endConversation() {
+	if s.userTurns > 0 {
+		conversationTurns.Observe(float64(s.userTurns))
+	}
+}
+
+// reset drops the conversation back to just the system prompt, or to
+// nothing for sessions started without one. Callers must hold s.mu.
+func (s *session) reset() {
+	s.endConversation()
+	s.history.clear()
+	s.userTurns = 0
+	s.title, s.titleTurns = "", 0
+}
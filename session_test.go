@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestPersonaMaxTokens(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Stream: []string{"Sure."}})
+
+	for _, tc := range []struct {
+		persona, body string
+		want          float64
+	}{
+		{persona: "tutor", want: 2048},
+		{persona: "bodha", want: 512},
+		{persona: "tutor", body: `, "max_tokens": 100`, want: 100},
+	} {
+		id := "persona-" + tc.persona
+		if tc.body != "" {
+			id += "-override"
+		}
+		req, _ := http.NewRequest("POST", srv.URL+"/api/chat/stream", strings.NewReader(`{"session_id": "`+id+`", "persona": "`+tc.persona+`", "message": "hi"`+tc.body+`}`))
+		resp, body := send(t, req)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status = %d, body %s", id, resp.StatusCode, body)
+		}
+		if got := upstream.LastRequest().Body["max_tokens"]; got != tc.want {
+			t.Errorf("%s: max_tokens = %v, want %v", id, got, tc.want)
+		}
+	}
+}
+
+func TestTemperatureAnnealing(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+	t.Setenv("TEMP_ANNEAL", "true")
+	t.Setenv("TEMP_ANNEAL_START", "1.0")
+	t.Setenv("TEMP_ANNEAL_STEP", "0.25")
+	t.Setenv("TEMP_ANNEAL_FLOOR", "0.4")
+
+	var got []interface{}
+	for i := 0; i < 5; i++ {
+		if resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "anneal", Message: "hi"}); resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+		}
+		got = append(got, upstream.LastRequest().Body["temperature"])
+	}
+	want := []interface{}{1.0, 0.75, 0.5, 0.4, 0.4}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("temperatures = %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestSessionIDValidation(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+	t.Setenv("MAX_SESSION_ID_LEN", "16")
+
+	for _, id := range []string{strings.Repeat("a", 17), "bad id!", "../etc", "über"} {
+		resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: id, Message: "hi"})
+		var reply ChatReply
+		json.Unmarshal(body, &reply)
+		if resp.StatusCode != http.StatusBadRequest || reply.Field != "session_id" {
+			t.Errorf("%q: status = %d, reply = %+v, want a 400 on session_id", id, resp.StatusCode, reply)
+		}
+	}
+	if n := len(upstream.Requests()); n != 0 {
+		t.Errorf("invalid IDs reached upstream %d times", n)
+	}
+	if resp, _ := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "ok-" + strings.Repeat("a", 13), Message: "hi"}); resp.StatusCode != http.StatusOK {
+		t.Errorf("valid ID at the cap: status = %d", resp.StatusCode)
+	}
+}
+
+func TestStatelessMode(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Forgotten."})
+	t.Setenv("STATELESS_MODE", "true")
+
+	for _, msg := range []string{"one", "two", "three"} {
+		if resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "ghost", Message: msg}); resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+		}
+		sent := sentMessages(t, upstream.LastRequest())
+		if len(sent) != 2 || sent[0].Role != "system" || sent[1].Content != msg {
+			t.Errorf("payload for %q = %+v, want only the system prompt and the message", msg, sent)
+		}
+	}
+	if ids, _ := store.List(); len(ids) != 0 {
+		t.Errorf("store holds sessions %v", ids)
+	}
+	if resp, _ := do(t, "GET", srv.URL+"/api/history?session_id=ghost", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("history: status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestPerTurnPersona(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+	withPersona(t, "host", Persona{SystemPrompt: "You are the host.", Params: GenParams{MaxTokens: 100}})
+	withPersona(t, "guest", Persona{SystemPrompt: "You are the guest.", Params: GenParams{MaxTokens: 200}})
+
+	for _, tc := range []struct{ persona, system string }{
+		{"host", "You are the host."},
+		{"guest", "You are the guest."},
+		{"", "You are the host."},
+	} {
+		do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "mixed", Persona: tc.persona, Message: "hi"})
+		if got := sentMessages(t, upstream.LastRequest())[0].Content; got != tc.system {
+			t.Errorf("persona %q: system message = %q, want %q", tc.persona, got, tc.system)
+		}
+	}
+	if got := upstream.Requests()[1].Body["max_tokens"]; got != 200.0 {
+		t.Errorf("guest turn: max_tokens = %v, want the guest's", got)
+	}
+
+	resp, _ := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "mixed", Persona: "nobody", Message: "hi"})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown persona override: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestModelPin(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+	other := GenParams{Model: "zai-glm-4.7"}
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "pinned", Message: "one"})
+
+	// keep (default): the request's model is ignored
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "pinned", Message: "two", GenParams: other})
+	if got := upstream.LastRequest().Body["model"]; got != "gpt-oss-120b" {
+		t.Errorf("keep: model = %v, want the session's", got)
+	}
+
+	t.Setenv("MODEL_PIN", "reject")
+	calls := len(upstream.Requests())
+	resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "pinned", Message: "three", GenParams: other})
+	var reply ChatReply
+	json.Unmarshal(body, &reply)
+	if resp.StatusCode != http.StatusBadRequest || reply.Field != "model" {
+		t.Errorf("reject: status = %d, reply = %+v", resp.StatusCode, reply)
+	}
+	if len(upstream.Requests()) != calls {
+		t.Error("rejected turn reached upstream")
+	}
+	for _, m := range history(t, srv.URL, "pinned") {
+		if m.Content == "three" {
+			t.Error("rejected message stored")
+		}
+	}
+
+	t.Setenv("MODEL_PIN", "off")
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "pinned", Message: "four", GenParams: other})
+	if got := upstream.LastRequest().Body["model"]; got != "zai-glm-4.7" {
+		t.Errorf("off: model = %v, want the requested one", got)
+	}
+}
+
+func TestHistoryWindow(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+	for _, msg := range []string{"one", "two", "three"} {
+		do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "window", Message: msg})
+	}
+	sentTexts := func() string {
+		var out []string
+		for _, m := range sentMessages(t, upstream.LastRequest())[1:] {
+			out = append(out, m.Content)
+		}
+		return strings.Join(out, "|")
+	}
+
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "window", Message: "four", HistoryWindow: 3})
+	if got := sentTexts(); got != "three|Ok.|four" {
+		t.Errorf("window 3: sent %q", got)
+	}
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "window", Message: "five"})
+	if got := sentTexts(); got != "one|Ok.|two|Ok.|three|Ok.|four|Ok.|five" {
+		t.Errorf("no window: sent %q, want everything stored", got)
+	}
+
+	t.Setenv("MAX_HISTORY_WINDOW", "2")
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "window", Message: "six", HistoryWindow: 10})
+	if got := sentTexts(); got != "Ok.|six" {
+		t.Errorf("window over the max: sent %q, want it capped at 2", got)
+	}
+
+	resp, _ := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "window", Message: "hi", HistoryWindow: -1})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("negative window: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestSystemAppend(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Yes.", Stream: []string{"Yes."}})
+	withPersona(t, "host", Persona{SystemPrompt: "You are the host."})
+	const extra = "Answer in one word."
+
+	resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "append", Persona: "host", Message: "hi", SystemAppend: extra})
+	var reply ChatReply
+	json.Unmarshal(body, &reply)
+	if resp.StatusCode != http.StatusBadRequest || reply.Field != "system_append" {
+		t.Errorf("without ALLOW_SYSTEM_APPEND: status = %d, body %s, want a 400 on system_append", resp.StatusCode, body)
+	}
+
+	t.Setenv("ALLOW_SYSTEM_APPEND", "true")
+	for _, endpoint := range []string{"/api/chat", "/api/chat/stream"} {
+		do(t, "POST", srv.URL+endpoint, ChatRequest{SessionID: "append", Persona: "host", Message: "hi", SystemAppend: extra})
+		if got, want := sentMessages(t, upstream.LastRequest())[0].Content, "You are the host.\n\n"+extra; got != want {
+			t.Errorf("%s: system message = %q, want %q", endpoint, got, want)
+		}
+	}
+
+	// the next turn is back to the plain prompt and nothing was stored
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "append", Message: "hi"})
+	if got := sentMessages(t, upstream.LastRequest())[0].Content; got != "You are the host." {
+		t.Errorf("next turn: system message = %q, want the persona's own", got)
+	}
+	sess, _, err := findSession(sessionKey("append"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sess.system, extra) {
+		t.Errorf("stored system prompt = %q, want it without the appended instruction", sess.system)
+	}
+	for _, m := range sess.history.slice() {
+		if strings.Contains(m.Content, extra) {
+			t.Errorf("stored message %+v holds the appended instruction", m)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"testing"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func sign(secret, data string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(data))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestRequestSignature(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Signed.", Stream: []string{"Signed."}})
+	t.Setenv("REQUEST_SIGNING_SECRET", "shared-secret")
+	body := `{"session_id": "signed", "message": "hi"}`
+
+	post := func(sig string) int {
+		req, _ := http.NewRequest("POST", srv.URL+"/api/chat", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		if sig != "" {
+			req.Header.Set("X-Signature", sig)
+		}
+		resp, _ := send(t, req)
+		return resp.StatusCode
+	}
+	for name, tc := range map[string]struct {
+		sig    string
+		status int
+	}{
+		"valid":    {sign("shared-secret", body), http.StatusOK},
+		"prefixed": {"sha256=" + sign("shared-secret", body), http.StatusOK},
+		"invalid":  {sign("wrong-secret", body), http.StatusForbidden},
+		"garbage":  {"not-hex", http.StatusForbidden},
+		"missing":  {"", http.StatusForbidden},
+	} {
+		if status := post(tc.sig); status != tc.status {
+			t.Errorf("POST %s signature: status = %d, want %d", name, status, tc.status)
+		}
+	}
+	if n := len(upstream.Requests()); n != 2 {
+		t.Errorf("upstream got %d calls, want only the signed ones", n)
+	}
+
+	// GET signs the query, with the signature as a parameter or header
+	query := "session_id=signed&message=hi"
+	for name, tc := range map[string]struct {
+		url, header string
+		status      int
+	}{
+		"sig param": {"/api/chat/stream?" + query + "&sig=" + sign("shared-secret", query), "", http.StatusOK},
+		"header":    {"/api/chat/stream?" + query, sign("shared-secret", query), http.StatusOK},
+		"tampered":  {"/api/chat/stream?" + query + "!&sig=" + sign("shared-secret", query), "", http.StatusForbidden},
+		"unsigned":  {"/api/chat/stream?" + query, "", http.StatusForbidden},
+	} {
+		req, _ := http.NewRequest("GET", srv.URL+tc.url, nil)
+		if tc.header != "" {
+			req.Header.Set("X-Signature", tc.header)
+		}
+		if resp, _ := send(t, req); resp.StatusCode != tc.status {
+			t.Errorf("GET %s: status = %d, want %d", name, resp.StatusCode, tc.status)
+		}
+	}
+}
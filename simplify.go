@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+type simplifyRule struct {
+	re   *regexp.Regexp
+	with string
+}
+
+// simplifyRules is loaded once from SIMPLIFY_DICT_PATH.
+var simplifyRules = sync.OnceValue(loadSimplifyRules)
+
+// loadSimplifyRules reads SIMPLIFY_DICT_PATH, a JSON object mapping
+// complex phrases to simpler ones. Nil disables the filter.
+func loadSimplifyRules() []simplifyRule {
+	path := os.Getenv("SIMPLIFY_DICT_PATH")
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logger.Error("simplify dictionary load failed", "path", path, "error", err)
+		return nil
+	}
+	var dict map[string]string
+	if err := json.Unmarshal(data, &dict); err != nil {
+		logger.Error("simplify dictionary parse failed", "path", path, "error", err)
+		return nil
+	}
+
+	// longest phrases first so "utilize fully" wins over "utilize"
+	phrases := make([]string, 0, len(dict))
+	for phrase := range dict {
+		if phrase != "" {
+			phrases = append(phrases, phrase)
+		}
+	}
+	sort.Slice(phrases, func(i, j int) bool { return len(phrases[i]) > len(phrases[j]) })
+
+	rules := make([]simplifyRule, 0, len(phrases))
+	for _, phrase := range phrases {
+		rules = append(rules, simplifyRule{
+			re:   regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(phrase) + `\b`),
+			with: dict[phrase],
+		})
+	}
+	return rules
+}
+
+// simplifyReply swaps jargon for plainer words using the configured
+// dictionary. Matching is case-insensitive and respects word boundaries.
+func simplifyReply(reply string) string {
+	for _, rule := range simplifyRules() {
+		reply = rule.re.ReplaceAllLiteralString(reply, rule.with)
+	}
+	return reply
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestSimplifyDictionary(t *testing.T) {
+	srv, _ := newTestServer(t, cerebrastest.Response{Content: "Utilize the API to utilize fully, not reutilize."})
+	path := filepath.Join(t.TempDir(), "dict.json")
+	os.WriteFile(path, []byte(`{"utilize": "use", "utilize fully": "use all of"}`), 0o600)
+	t.Setenv("SIMPLIFY_DICT_PATH", path)
+	setVar(t, &simplifyRules, sync.OnceValue(loadSimplifyRules))
+
+	_, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "simple", Message: "hi"})
+	var reply ChatReply
+	json.Unmarshal(body, &reply)
+	if want := "use the API to use all of, not reutilize."; reply.Reply != want {
+		t.Errorf("reply = %q, want %q", reply.Reply, want)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatelessRequest carries the whole conversation from the client; nothing
+// is stored server-side.
+type StatelessRequest struct {
+	Messages []Message `json:"messages"`
+	Persona  string    `json:"persona,omitempty"`
+	Raw      bool      `json:"raw,omitempty"`
+	GenParams
+}
+
+// roleAliases maps client role names onto the canonical set, configured
+// as ROLE_ALIASES=alias=role,... (e.g. "human=user,ai=assistant").
+var roleAliases = parseRoleAliases(envList("ROLE_ALIASES", []string{
+	"human=user",
+	"ai=assistant",
+	"bot=assistant",
+	"model=assistant",
+}))
+
+func parseRoleAliases(pairs []string) map[string]string {
+	aliases := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		alias, role, ok := strings.Cut(pair, "=")
+		if !ok {
+			logger.Warn("ignoring invalid role alias", "entry", pair)
+			continue
+		}
+		aliases[strings.ToLower(strings.TrimSpace(alias))] = strings.ToLower(strings.TrimSpace(role))
+	}
+	return aliases
+}
+
+// normalizeRole lowercases role and resolves any configured alias.
+func normalizeRole(role string) string {
+	role = strings.ToLower(strings.TrimSpace(role))
+	if canonical, ok := roleAliases[role]; ok {
+		return canonical
+	}
+	return role
+}
+
+// handleStateless completes a client-supplied conversation. The persona's
+// system prompt is always prepended so clients can't replace it.
+func handleStateless(w http.ResponseWriter, r *http.Request) {
+	var req StatelessRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeErrorStatus(w, r, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	if len(req.Messages) == 0 {
+		writeInvalid(w, r, invalid("messages", "is required"))
+		return
+	}
+	if err := validateGenParams(req.GenParams); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	if max := envInt("MAX_STATELESS_MESSAGES", 50); len(req.Messages) > max {
+		writeInvalid(w, r, invalid("messages", "too many messages: %d (max %d)", len(req.Messages), max))
+		return
+	}
+	if max, n := envInt("MAX_STATELESS_TOKENS", 8000), estimateTokens(req.Messages); n > max {
+		writeInvalid(w, r, invalid("messages", "conversation too long: ~%d tokens (max %d)", n, max))
+		return
+	}
+
+	personaName := req.Persona
+	if personaName == "" {
+		personaName = defaultPersona
+	}
+	persona, ok := personas[personaName]
+	if !ok {
+		writeInvalid(w, r, invalid("persona", "unknown persona %q", personaName))
+		return
+	}
+
+	msgs := make([]Message, 0, len(req.Messages)+1)
+	msgs = append(msgs, Message{Role: "system", Content: persona.SystemPrompt})
+	for i, m := range req.Messages {
+		m.Role = normalizeRole(m.Role)
+		if m.Role != "user" && m.Role != "assistant" {
+			writeInvalid(w, r, invalid(fmt.Sprintf("messages[%d].role", i), "unsupported role %q", req.Messages[i].Role))
+			return
+		}
+		if err := validatePromptLength(fmt.Sprintf("messages[%d].content", i), m.Content); err != nil {
+			writeInvalid(w, r, err)
+			return
+		}
+		// the client supplies the whole history, so every user message
+		// is checked, not just the newest
+		if m.Role == "user" && blockInjection(r.Context(), m.Content) {
+			writeReply(w, r, ChatReply{Reply: dismissal(persona), Blocked: true})
+			return
+		}
+		msgs = append(msgs, m)
+	}
+
+	params := defaultParams.merge(persona.Params).merge(req.GenParams)
+	start := time.Now()
+	apiRes, err := complete(r.Context(), msgs, params)
+	if err != nil {
+		writeUpstreamFailure(w, r, err)
+		return
+	}
+
+	logTurn(r.Context(), "stateless", params, apiRes.Usage, time.Since(start))
+	forwardRateLimits(w, apiRes.rateLimits)
+	w.Header().Set("X-Retry-Count", strconv.Itoa(apiRes.retries))
+	reply := apiRes.Choices[0].Message.Content
+	if !jsonMode(params) {
+		reply = simplifyReply(reply)
+	}
+	if err := checkJSONReply(params, reply); err != nil {
+		writeErrorStatus(w, r, http.StatusBadGateway, err.Error())
+		return
+	}
+	out := ChatReply{Reply: reply, Truncated: apiRes.Choices[0].FinishReason == "length", Created: createdAt(apiRes), Model: echoedModel(apiRes)}
+	if wantRaw(r, req.Raw) {
+		out.Raw = apiRes
+	}
+	writeJSON(w, r, http.StatusOK, out)
+}
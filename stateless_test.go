@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestStatelessLimits(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+	t.Setenv("MAX_STATELESS_MESSAGES", "4")
+	t.Setenv("MAX_STATELESS_TOKENS", "100")
+
+	many := make([]Message, 5)
+	for i := range many {
+		many[i] = Message{Role: "user", Content: "hi"}
+	}
+	long := []Message{{Role: "user", Content: strings.Repeat("word ", 200)}}
+
+	for name, tc := range map[string]struct {
+		msgs []Message
+		want string
+	}{
+		"too many": {many, "too many messages: 5 (max 4)"},
+		"too long": {long, "conversation too long"},
+	} {
+		resp, body := do(t, "POST", srv.URL+"/api/chat/stateless", StatelessRequest{Messages: tc.msgs})
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", name, resp.StatusCode)
+		}
+		var reply ChatReply
+		json.Unmarshal(body, &reply)
+		if !strings.HasPrefix(reply.Error, tc.want) || reply.Field != "messages" {
+			t.Errorf("%s: error = %q on %q, want %q on messages", name, reply.Error, reply.Field, tc.want)
+		}
+	}
+	if len(upstream.Requests()) != 0 {
+		t.Error("oversized requests reached upstream")
+	}
+
+	if resp, body := do(t, "POST", srv.URL+"/api/chat/stateless", StatelessRequest{Messages: many[:4]}); resp.StatusCode != http.StatusOK {
+		t.Errorf("at the limit: status = %d, body %s", resp.StatusCode, body)
+	}
+}
+
+func TestStatelessRoleAliases(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+
+	resp, body := do(t, "POST", srv.URL+"/api/chat/stateless", StatelessRequest{Messages: []Message{
+		{Role: "Human", Content: "hi"},
+		{Role: "AI", Content: "hello"},
+		{Role: "user", Content: "again"},
+	}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+	}
+	var roles []string
+	for _, m := range sentMessages(t, upstream.LastRequest()) {
+		roles = append(roles, m.Role)
+	}
+	if got := strings.Join(roles, ","); got != "system,user,assistant,user" {
+		t.Errorf("roles sent = %s", got)
+	}
+
+	resp, body = do(t, "POST", srv.URL+"/api/chat/stateless", StatelessRequest{Messages: []Message{
+		{Role: "user", Content: "hi"},
+		{Role: "narrator", Content: "meanwhile"},
+	}})
+	var reply ChatReply
+	json.Unmarshal(body, &reply)
+	if resp.StatusCode != http.StatusBadRequest || reply.Field != "messages[1].role" {
+		t.Errorf("unknown role: status = %d, reply = %+v", resp.StatusCode, reply)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// tokenStats is the running total of tokens consumed upstream since
+// startup, across every endpoint and retry.
+var tokenStats struct {
+	prompt     atomic.Int64
+	completion atomic.Int64
+}
+
+var startedAt = time.Now()
+
+// recordUsage adds one upstream call's usage to the running totals.
+func recordUsage(usage Usage) {
+	tokenStats.prompt.Add(int64(usage.PromptTokens))
+	tokenStats.completion.Add(int64(usage.CompletionTokens))
+	tokensConsumed.WithLabelValues("prompt").Add(float64(usage.PromptTokens))
+	tokensConsumed.WithLabelValues("completion").Add(float64(usage.CompletionTokens))
+}
+
+type StatsReply struct {
+	PromptTokens     int64     `json:"prompt_tokens"`
+	CompletionTokens int64     `json:"completion_tokens"`
+	TotalTokens      int64     `json:"total_tokens"`
+	Since            time.Time `json:"since"`
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	prompt, completion := tokenStats.prompt.Load(), tokenStats.completion.Load()
+	writeJSON(w, r, http.StatusOK, StatsReply{
+		PromptTokens:     prompt,
+		CompletionTokens: completion,
+		TotalTokens:      prompt + completion,
+		Since:            startedAt.UTC(),
+	})
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestStatsTotals(t *testing.T) {
+	srv, _ := newTestServer(t,
+		cerebrastest.Response{Content: "One.", Usage: &cerebrastest.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}},
+		cerebrastest.Response{Stream: []string{"Two."}, Usage: &cerebrastest.Usage{PromptTokens: 20, CompletionTokens: 7, TotalTokens: 27}},
+	)
+	tokenStats.prompt.Store(0)
+	tokenStats.completion.Store(0)
+
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "stats", Message: "one"})
+	do(t, "POST", srv.URL+"/api/chat/stream", ChatRequest{SessionID: "stats", Message: "two"})
+
+	_, body := do(t, "GET", srv.URL+"/api/stats", nil)
+	var stats StatsReply
+	if err := json.Unmarshal(body, &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.PromptTokens != 30 || stats.CompletionTokens != 12 || stats.TotalTokens != 42 {
+		t.Errorf("stats = %+v, want 30 prompt and 12 completion tokens", stats)
+	}
+	if stats.Since.IsZero() {
+		t.Error("since missing")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// errSessionStore marks failures of the session store itself, as opposed
+// to bad requests.
+var errSessionStore = errors.New("session store unavailable")
+
+// SessionStore persists sessions by ID. Handlers mutate a session under its
+// lock and then Save it, so backends that copy state out (rather than
+// keeping the pointer) see every change.
+type SessionStore interface {
+	// Get returns the session stored under id; ok is false if there is none.
+	Get(id string) (s *session, ok bool, err error)
+	Save(id string, s *session) error
+	Delete(id string) error
+	// List returns every stored session ID, sorted.
+	List() ([]string, error)
+}
+
+// memoryStore keeps sessions in a map. It hands out the stored pointer,
+// so sessions are shared and Save only matters for new ones. Sessions
+// idle for longer than ttl expire; they are dropped lazily, when looked up
+// or when a new session is stored. A zero ttl keeps them forever.
+type memoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+	ttl      time.Duration
+}
+
+func newMemoryStore(ttl time.Duration) *memoryStore {
+	return &memoryStore{sessions: map[string]*session{}, ttl: ttl}
+}
+
+// expire drops s if it has been idle for longer than the TTL, recording
+// the conversation's end, and reports whether it did. A session with a
+// turn in flight is never idle. Callers must hold m.mu.
+func (m *memoryStore) expire(id string, s *session) bool {
+	if m.ttl <= 0 || !s.mu.TryRLock() {
+		return false
+	}
+	defer s.mu.RUnlock()
+	if time.Since(s.lastActive) <= m.ttl {
+		return false
+	}
+	s.endConversation()
+	delete(m.sessions, id)
+	return true
+}
+
+func (m *memoryStore) Get(id string) (*session, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if ok && m.expire(id, s) {
+		return nil, false, nil
+	}
+	return s, ok, nil
+}
+
+func (m *memoryStore) Save(id string, s *session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sessions[id]; !ok {
+		// a new session is rare enough to pay for a sweep of idle ones
+		for other, old := range m.sessions {
+			m.expire(other, old)
+		}
+	}
+	m.sessions[id] = s
+	return nil
+}
+
+func (m *memoryStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *memoryStore) List() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := make([]string, 0, len(m.sessions))
+	for id, s := range m.sessions {
+		if !m.expire(id, s) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+var store SessionStore = newMemoryStore(0)
+
+// saveSession persists sess after a change. Failures are only logged: the
+// response has usually been written by the time it runs. Callers must
+// hold sess.mu.
+func saveSession(id string, sess *session) {
+	if envBool("STATELESS_MODE", false) || sess.deleted {
+		return
+	}
+	if err := store.Save(sessionKey(id), sess); err != nil {
+		logger.Error("session save failed", "session_id", sessionKey(id), "error", err)
+	}
+}
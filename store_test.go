@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// storedSession returns a new default-persona session holding msgs.
+func storedSession(t *testing.T, msgs ...string) *session {
+	t.Helper()
+	s, err := newSession(&ChatRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range msgs {
+		s.append(Message{Role: "user", Content: m})
+	}
+	return s
+}
+
+// testSessionStore checks the SessionStore contract on an empty store.
+func testSessionStore(t *testing.T, st SessionStore) {
+	t.Helper()
+	if _, ok, err := st.Get("missing"); ok || err != nil {
+		t.Errorf("Get of a missing session: ok = %v, err = %v", ok, err)
+	}
+	if ids, err := st.List(); len(ids) != 0 || err != nil {
+		t.Errorf("List of an empty store = %v, %v", ids, err)
+	}
+
+	for _, id := range []string{"b", "a", "c"} {
+		if err := st.Save(id, storedSession(t, "hello from "+id)); err != nil {
+			t.Fatalf("Save(%q): %v", id, err)
+		}
+	}
+	s, ok, err := st.Get("a")
+	if !ok || err != nil {
+		t.Fatalf("Get(a): ok = %v, err = %v", ok, err)
+	}
+	s.mu.Lock()
+	got := s.history.slice()
+	s.append(Message{Role: "assistant", Content: "hi"})
+	s.mu.Unlock()
+	if len(got) != 1 || got[0].Content != "hello from a" || got[0].ID != "m1" {
+		t.Errorf("Get(a) history = %+v", got)
+	}
+	if s.personaName != defaultPersona || s.system == "" || s.model == "" {
+		t.Errorf("Get(a) lost session fields: persona %q, model %q", s.personaName, s.model)
+	}
+
+	// a change is visible once saved
+	if err := st.Save("a", s); err != nil {
+		t.Fatal(err)
+	}
+	s, _, _ = st.Get("a")
+	s.mu.RLock()
+	if got := strings.Join(contents(s.history.slice()), "|"); got != "hello from a|hi" || s.lastID != 2 {
+		t.Errorf("after saving a change: history %q, last ID %d", got, s.lastID)
+	}
+	s.mu.RUnlock()
+
+	if ids, err := st.List(); strings.Join(ids, ",") != "a,b,c" || err != nil {
+		t.Errorf("List = %v, %v, want a,b,c", ids, err)
+	}
+	if err := st.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := st.Get("b"); ok {
+		t.Error("Get after Delete found the session")
+	}
+	if err := st.Delete("b"); err != nil {
+		t.Errorf("deleting a missing session: %v", err)
+	}
+	if ids, _ := st.List(); strings.Join(ids, ",") != "a,c" {
+		t.Errorf("List after Delete = %v", ids)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testSessionStore(t, newMemoryStore(0))
+}
+
+func TestMemoryStoreTTL(t *testing.T) {
+	st := newMemoryStore(time.Hour)
+	idle, fresh := storedSession(t, "old"), storedSession(t, "new")
+	st.Save("idle", idle)
+	st.Save("fresh", fresh)
+	idle.lastActive = time.Now().Add(-2 * time.Hour)
+
+	if ids, _ := st.List(); strings.Join(ids, ",") != "fresh" {
+		t.Errorf("List = %v, want the idle session expired", ids)
+	}
+	if _, ok, _ := st.Get("idle"); ok {
+		t.Error("Get found an expired session")
+	}
+	if _, ok, _ := st.Get("fresh"); !ok {
+		t.Error("fresh session expired")
+	}
+
+	// a session mid-turn isn't idle, however old its last activity
+	fresh.lastActive = time.Now().Add(-2 * time.Hour)
+	fresh.mu.Lock()
+	_, ok, _ := st.Get("fresh")
+	fresh.mu.Unlock()
+	if !ok {
+		t.Error("session with a turn in flight expired")
+	}
+}
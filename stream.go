@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type streamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	// Usage is only present on the final chunk when include_usage is set.
+	Usage *Usage `json:"usage"`
+}
+
+type streamDelta struct {
+	Delta string `json:"delta"`
+}
+
+// activeStreams counts streams currently open, for MAX_CONCURRENT_STREAMS.
+var activeStreams atomic.Int64
+
+// handleChatStream relays a completion to the client as Server-Sent Events.
+// GET (for EventSource) takes the message from ?message=, POST takes a
+// ChatRequest body. While waiting on upstream, for its first byte as much
+// as between deltas, it emits ": ping" comments every
+// SSE_KEEPALIVE_INTERVAL so proxies don't drop the idle connection.
+// Time to the first model token is recorded in the TTFT histogram and sent
+// as the X-TTFT-Ms trailer, since headers are long gone by then.
+func handleChatStream(w http.ResponseWriter, r *http.Request) {
+	received := time.Now()
+
+	// streams are long-lived, so they get their own cap; priority streams
+	// count towards it but are never refused
+	n := activeStreams.Add(1)
+	defer activeStreams.Add(-1)
+	if max := envInt("MAX_CONCURRENT_STREAMS", 0); max > 0 && n > int64(max) && !isPriority(r) {
+		w.Header().Set("Retry-After", "5")
+		writeErrorStatus(w, r, http.StatusServiceUnavailable, "too many streams in progress, try again shortly")
+		return
+	}
+
+	var req ChatRequest
+	switch r.Method {
+	case http.MethodGet:
+		req.Message = r.URL.Query().Get("message")
+		req.SessionID = r.URL.Query().Get("session_id")
+		req.Persona = r.URL.Query().Get("persona")
+		req.Language = r.URL.Query().Get("language")
+	case http.MethodPost:
+		if err := decodeJSON(r, &req); err != nil {
+			writeErrorStatus(w, r, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+			return
+		}
+	}
+	req.SessionID = withCookieSession(w, r, req.SessionID)
+	req.Message = normalizeMessage(req.Message)
+	if req.Message == "" {
+		writeInvalid(w, r, invalid("message", "is required"))
+		return
+	}
+	if err := validateTurnOptions(&req); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, "Streaming unsupported")
+		return
+	}
+
+	sess, err := getSession(&req)
+	if err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	if !sess.lockTurn() {
+		writeErrorStatus(w, r, http.StatusConflict, "request in progress")
+		return
+	}
+	defer sess.unlockTurn()
+	defer saveSession(req.SessionID, sess)
+	if err := sess.checkTurn(&req); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	if blockInjection(r.Context(), req.Message) {
+		reply := sess.turnDismissal(&req)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		sse := &sseWriter{w: w, rc: http.NewResponseController(w), timeout: envDuration("STREAM_WRITE_TIMEOUT", 10*time.Second)}
+		sse.event("", streamDelta{Delta: reply})
+		sse.event("done", ChatReply{Reply: reply, Blocked: true})
+		return
+	}
+
+	userID := sess.append(Message{
+		Role:    "user",
+		Content: req.Message,
+	})
+
+	conv, params, err := sess.forTurn(&req)
+	if err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	ctx, span := startUpstreamSpan(r.Context(), params, true)
+	var streamErr error
+	var usage Usage
+	var finishReason string
+	defer func() {
+		recordUsage(usage)
+		endUpstreamSpan(span, 0, usage, streamErr)
+	}()
+
+	// cancelling ctx aborts the upstream stream, e.g. on truncation or
+	// when the client asks via /api/cancel
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	defer trackStream(sessionKey(req.SessionID), cancel)()
+
+	start := time.Now()
+	// the headers go out before upstream answers, so the wait for its first
+	// byte is covered by pings too; failures from here on are error events
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Trailer", "X-TTFT-Ms")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	writeTimeout := envDuration("STREAM_WRITE_TIMEOUT", 10*time.Second)
+	sse := &sseWriter{w: w, rc: http.NewResponseController(w), timeout: writeTimeout}
+	// don't leak our deadline onto the next request on this connection
+	defer sse.rc.SetWriteDeadline(time.Time{})
+
+	keepalive := time.NewTicker(envDuration("SSE_KEEPALIVE_INTERVAL", 15*time.Second))
+	defer keepalive.Stop()
+
+	resp, err := awaitUpstream(keepalive.C, func() { sse.comment("ping") }, func() (*http.Response, error) {
+		return openStream(ctx, withAssistantPrefix(withContextDocs(withLanguage(conv, req.Language), req.Context), req.AssistantPrefix), params)
+	})
+	if err != nil {
+		streamErr = err
+		sse.event("error", ChatReply{Error: err.Error()})
+		return
+	}
+	// cancel first so a half-read stream aborts instead of being drained,
+	// and wait for the reader so nothing touches the body concurrently
+	readerDone := make(chan struct{})
+	defer func() {
+		cancel(nil)
+		<-readerDone
+		closeBody(resp)
+	}()
+
+	body, err := decodedBody(resp)
+	if err != nil {
+		close(readerDone)
+		streamErr = err
+		sse.event("error", ChatReply{Error: "Stream read error: " + err.Error()})
+		return
+	}
+
+	// the buffer lets upstream run ahead of a briefly slow client; once it
+	// stays full for writeTimeout the reader gives up and the stream aborts
+	deltas := make(chan string, envInt("STREAM_CLIENT_BUFFER", 64))
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(readerDone)
+		defer close(deltas)
+		readErr <- readUpstreamStream(ctx, body, deltas, &usage, &finishReason, writeTimeout)
+	}()
+
+	maxBytes := envInt("STREAM_MAX_BYTES", 0)
+
+	// ttft is the time to the first model delta; zero until it arrives
+	var ttft time.Duration
+	turnAttrs := func() []slog.Attr {
+		if ttft == 0 {
+			return nil
+		}
+		return []slog.Attr{slog.Int64("ttft_ms", ttft.Milliseconds())}
+	}
+
+	var reply strings.Builder
+	var echo string
+	if req.EchoMessage {
+		echo = req.Message
+	}
+	if req.AssistantPrefix != "" {
+		// the model continues from the prefix, so it is part of the reply
+		reply.WriteString(req.AssistantPrefix)
+		sse.event("", streamDelta{Delta: req.AssistantPrefix})
+	}
+
+	// keepPartial stores what has streamed so far, flagged truncated, and
+	// closes the stream with it. Used when we cut generation short.
+	keepPartial := func(event string) {
+		replyID := sess.append(Message{
+			Role:      "assistant",
+			Content:   reply.String(),
+			Truncated: true,
+		})
+		logTurn(ctx, "stream", params, usage, time.Since(start), turnAttrs()...)
+		writeAudit(ctx, auditRecord{SessionID: sessionKey(req.SessionID), Endpoint: "stream", Message: req.Message, Reply: reply.String()})
+		done := ChatReply{Reply: reply.String(), Truncated: true, Created: time.Now().Unix(), SystemHash: systemHash(conv), MessageID: replyID, UserMessageID: userID, Message: echo}
+		sse.event(event, done)
+		sse.event("done", done)
+	}
+
+	// cutShort hands back what has streamed so far when generation was
+	// stopped early: out of time (MAX_REQUEST_DURATION) or cancelled by the
+	// client. It reports whether it closed the stream.
+	cutShort := func() bool {
+		if reply.Len() == 0 {
+			return false
+		}
+		switch {
+		case errors.Is(context.Cause(ctx), errStreamCancelled):
+			keepPartial("cancelled")
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			keepPartial("timeout")
+		default:
+			return false
+		}
+		return true
+	}
+
+	for {
+		select {
+		case delta, ok := <-deltas:
+			if !ok {
+				if err := <-readErr; err != nil {
+					streamErr = err
+					if cutShort() {
+						return
+					}
+					sse.event("error", ChatReply{Error: "Stream read error: " + err.Error()})
+					return
+				}
+				logTurn(ctx, "stream", params, usage, time.Since(start), turnAttrs()...)
+				if usage.TotalTokens > 0 {
+					sse.event("usage", usage)
+				}
+				if strings.TrimSpace(reply.String()) == "" && envBool("RETRY_ON_EMPTY", false) {
+					// deltas are already out, so there is no retrying here,
+					// but the empty turn stays out of the history
+					sse.event("done", ChatReply{Reply: emptyReplyFallback()})
+					return
+				}
+				// finish_reason "length" means max_tokens cut the reply off
+				truncated := finishReason == "length"
+				replyID := sess.append(Message{
+					Role:      "assistant",
+					Content:   reply.String(),
+					Truncated: truncated,
+				})
+				writeAudit(ctx, auditRecord{SessionID: sessionKey(req.SessionID), Endpoint: "stream", Message: req.Message, Reply: reply.String()})
+				sse.event("done", ChatReply{Reply: reply.String(), Truncated: truncated, Created: time.Now().Unix(), SystemHash: systemHash(conv), MessageID: replyID, UserMessageID: userID, Message: echo})
+				return
+			}
+			if ttft == 0 {
+				ttft = time.Since(received)
+				streamTTFT.Observe(ttft.Seconds())
+				w.Header().Set("X-TTFT-Ms", strconv.FormatInt(ttft.Milliseconds(), 10))
+			}
+			reply.WriteString(delta)
+			if err := sse.event("", streamDelta{Delta: delta}); err != nil {
+				// client can't keep up or went away; the deferred cancel
+				// stops the upstream generation
+				streamErr = err
+				logger.Warn("stream aborted: client write failed", "request_id", requestIDFrom(ctx), "error", err)
+				return
+			}
+
+			if maxBytes > 0 && reply.Len() >= maxBytes {
+				// stop paying for tokens nobody will see and keep what we have
+				cancel(nil)
+				keepPartial("truncated")
+				return
+			}
+		case <-keepalive.C:
+			if err := sse.comment("ping"); err != nil {
+				streamErr = err
+				return
+			}
+		case <-ctx.Done():
+			streamErr = ctx.Err()
+			if !cutShort() {
+				sse.event("error", ChatReply{Error: "Stream stopped: " + context.Cause(ctx).Error()})
+			}
+			return
+		}
+	}
+}
+
+// awaitUpstream calls open, pinging on every tick until it returns, so
+// an upstream slow to send its headers doesn't leave the client idle.
+func awaitUpstream(tick <-chan time.Time, ping func(), open func() (*http.Response, error)) (*http.Response, error) {
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	opened := make(chan result, 1)
+	go func() {
+		resp, err := open()
+		opened <- result{resp, err}
+	}()
+	for {
+		select {
+		case res := <-opened:
+			return res.resp, res.err
+		case <-tick:
+			ping()
+		}
+	}
+}
+
+// readUpstreamStream parses the upstream SSE body and sends each content
+// delta on out until [DONE], EOF, or ctx is cancelled. If out stays full
+// for longer than stall the client is considered too slow and errSlowClient
+// is returned. Token usage from the final chunk is stored in usage and the
+// last finish_reason seen in finish; read them only after out is closed.
+func readUpstreamStream(ctx context.Context, body io.Reader, out chan<- string, usage *Usage, finish *string, stall time.Duration) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			return nil
+		}
+
+		var chunk streamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return err
+		}
+		if chunk.Usage != nil {
+			*usage = *chunk.Usage
+		}
+		if len(chunk.Choices) > 0 && chunk.Choices[0].FinishReason != "" {
+			*finish = chunk.Choices[0].FinishReason
+		}
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			timer := time.NewTimer(stall)
+			select {
+			case out <- chunk.Choices[0].Delta.Content:
+				timer.Stop()
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+				return errSlowClient
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+var errSlowClient = errors.New("client too slow to keep up with the stream")
+
+// sseWriter writes events under a per-write deadline, so a stalled client
+// surfaces as an error instead of blocking the handler (and the upstream
+// connection) indefinitely.
+type sseWriter struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+// event writes v as a single SSE event and flushes it. An empty name sends
+// an unnamed "message" event.
+func (s *sseWriter) event(name string, v interface{}) error {
+	data, _ := json.Marshal(v)
+	var b strings.Builder
+	if name != "" {
+		fmt.Fprintf(&b, "event: %s\n", name)
+	}
+	fmt.Fprintf(&b, "data: %s\n\n", data)
+	return s.write(b.String())
+}
+
+// comment writes an SSE comment line, used for keepalives.
+func (s *sseWriter) comment(text string) error {
+	return s.write(": " + text + "\n\n")
+}
+
+func (s *sseWriter) write(chunk string) error {
+	// not every ResponseWriter supports deadlines; carry on without one
+	s.rc.SetWriteDeadline(time.Now().Add(s.timeout))
+	if _, err := io.WriteString(s.w, chunk); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
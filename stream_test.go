@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+type sseEvent struct {
+	name string
+	data string
+}
+
+// parseSSE splits a stream body into its events, skipping comments.
+func parseSSE(body string) []sseEvent {
+	var events []sseEvent
+	for _, block := range strings.Split(body, "\n\n") {
+		var ev sseEvent
+		for _, line := range strings.Split(block, "\n") {
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				ev.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				ev.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+		if ev.name != "" || ev.data != "" {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+func TestStreamKeepalive(t *testing.T) {
+	srv, _ := newTestServer(t, cerebrastest.Response{
+		Delay:      150 * time.Millisecond,
+		Stream:     []string{"Slow ", "answer."},
+		ChunkDelay: 150 * time.Millisecond,
+	})
+	t.Setenv("SSE_KEEPALIVE_INTERVAL", "20ms")
+
+	req, _ := http.NewRequest("GET", srv.URL+"/api/chat/stream?message="+url.QueryEscape("hi"), nil)
+	req.Header.Set("Origin", "https://dibinxavier.github.io")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	for header, want := range map[string]string{
+		"Content-Type":                "text/event-stream",
+		"Cache-Control":               "no-cache",
+		"Connection":                  "keep-alive",
+		"Access-Control-Allow-Origin": "https://dibinxavier.github.io",
+	} {
+		if got := resp.Header.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+
+	// pings start while upstream hasn't answered yet
+	first := make([]byte, len(": ping\n\n"))
+	if _, err := resp.Body.Read(first); err != nil || string(first) != ": ping\n\n" {
+		t.Errorf("first bytes = %q, %v; want a ping before upstream answers", first, err)
+	}
+
+	var rest strings.Builder
+	if _, err := io.Copy(&rest, resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	body := rest.String()
+	if n := strings.Count(body, ": ping\n\n"); n < 2 {
+		t.Errorf("got %d keepalive comments during a slow stream, want several:\n%s", n, body)
+	}
+	if events := parseSSE(body); len(events) == 0 || events[len(events)-1].name != "done" {
+		t.Errorf("stream didn't finish with a done event:\n%s", body)
+	}
+}
+
+func TestStreamUpstreamError(t *testing.T) {
+	srv, _ := newTestServer(t, cerebrastest.Response{
+		Status: http.StatusServiceUnavailable,
+		Error:  "overloaded",
+		Delay:  50 * time.Millisecond,
+	})
+	t.Setenv("SSE_KEEPALIVE_INTERVAL", "10ms")
+
+	req, _ := http.NewRequest("GET", srv.URL+"/api/chat/stream?message=hi", nil)
+	resp, body := send(t, req)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want the stream already open", resp.StatusCode)
+	}
+	events := parseSSE(string(body))
+	if len(events) != 1 || events[0].name != "error" || !strings.Contains(events[0].data, "503") {
+		t.Errorf("events = %+v, want one error event carrying the upstream status", events)
+	}
+}
+
+func TestStreamMaxBytes(t *testing.T) {
+	deltas := make([]string, 50)
+	for i := range deltas {
+		deltas[i] = "word "
+	}
+	srv, _ := newTestServer(t, cerebrastest.Response{Stream: deltas, ChunkDelay: 2 * time.Millisecond})
+	t.Setenv("STREAM_MAX_BYTES", "12")
+
+	resp, body := do(t, "POST", srv.URL+"/api/chat/stream", ChatRequest{SessionID: "capped", Message: "ramble"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+	}
+	events := parseSSE(string(body))
+	var names []string
+	for _, ev := range events {
+		names = append(names, ev.name)
+	}
+	if got := strings.Join(names, ","); got != ",,,truncated,done" {
+		t.Errorf("events = %q, want three deltas then truncated and done", got)
+	}
+	var done ChatReply
+	json.Unmarshal([]byte(events[len(events)-1].data), &done)
+	if done.Reply != "word word word " || !done.Truncated {
+		t.Errorf("done = %+v, want the partial reply flagged truncated", done)
+	}
+
+	msgs := history(t, srv.URL, "capped")
+	if last := msgs[len(msgs)-1]; !last.Truncated || !strings.HasPrefix(last.Content, "word word word ") {
+		t.Errorf("stored reply = %+v, want the partial reply flagged truncated", last)
+	}
+}
+
+func TestStreamUsageEvent(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{
+		Stream: []string{"Coun", "ted."},
+		Usage:  &cerebrastest.Usage{PromptTokens: 12, CompletionTokens: 2, TotalTokens: 14},
+	})
+
+	_, body := do(t, "POST", srv.URL+"/api/chat/stream", ChatRequest{SessionID: "usage", Message: "hi"})
+	if opts, _ := upstream.LastRequest().Body["stream_options"].(map[string]interface{}); opts["include_usage"] != true {
+		t.Errorf("stream_options = %v, want include_usage", upstream.LastRequest().Body["stream_options"])
+	}
+	events := parseSSE(string(body))
+	if len(events) < 2 || events[len(events)-2].name != "usage" || events[len(events)-1].name != "done" {
+		t.Fatalf("stream didn't end with usage then done:\n%s", body)
+	}
+	var usage Usage
+	json.Unmarshal([]byte(events[len(events)-2].data), &usage)
+	if usage.PromptTokens != 12 || usage.CompletionTokens != 2 || usage.TotalTokens != 14 {
+		t.Errorf("usage = %+v", usage)
+	}
+
+	// no usage chunk upstream, no usage event
+	upstream.Enqueue(cerebrastest.Response{Stream: []string{"Free."}})
+	_, body = do(t, "POST", srv.URL+"/api/chat/stream", ChatRequest{SessionID: "usage", Message: "again"})
+	for _, ev := range parseSSE(string(body)) {
+		if ev.name == "usage" {
+			t.Errorf("usage event without upstream usage: %s", ev.data)
+		}
+	}
+}
+
+func TestStreamSlowClient(t *testing.T) {
+	// far more than the socket buffers hold, so writes to a client that
+	// never reads eventually block
+	deltas := make([]string, 1000)
+	for i := range deltas {
+		deltas[i] = strings.Repeat("x", 16<<10)
+	}
+	srv, upstream := newTestServer(t, cerebrastest.Response{Stream: deltas})
+	t.Setenv("STREAM_WRITE_TIMEOUT", "50ms")
+	t.Setenv("STREAM_CLIENT_BUFFER", "4")
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET /api/chat/stream?session_id=slow&message=hi HTTP/1.1\r\nHost: test\r\n\r\n")
+
+	waitFor(t, "the stream to start", func() bool { return len(upstream.Requests()) == 1 })
+	// the turn holds the session until the handler returns
+	waitFor(t, "the stalled stream to abort", func() bool {
+		sess, ok, _ := findSession("slow")
+		if !ok || !sess.mu.TryLock() {
+			return false
+		}
+		sess.mu.Unlock()
+		return true
+	})
+}
+
+// openSlowStream starts a stream that stays open until the returned func
+// is called, which waits for it to finish.
+func openSlowStream(t *testing.T, srv string, session string) func() {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, err := http.Post(srv+"/api/chat/stream", "application/json",
+			strings.NewReader(`{"session_id": "`+session+`", "message": "slow"}`))
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}()
+	waitFor(t, "the stream to open", func() bool { return activeStreams.Load() == 1 })
+	return func() { <-done }
+}
+
+func TestMaxConcurrentStreams(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Stream: []string{"Slow ", "stream."}, ChunkDelay: 200 * time.Millisecond})
+	t.Setenv("MAX_CONCURRENT_STREAMS", "1")
+
+	wait := openSlowStream(t, srv.URL, "first")
+	resp, body := do(t, "POST", srv.URL+"/api/chat/stream", ChatRequest{SessionID: "second", Message: "hi"})
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("over the cap: status = %d, Retry-After = %q, body %s", resp.StatusCode, resp.Header.Get("Retry-After"), body)
+	}
+	if n := len(upstream.Requests()); n != 1 {
+		t.Errorf("upstream got %d calls, want the refused stream kept off it", n)
+	}
+	wait()
+
+	upstream.Enqueue(cerebrastest.Response{Stream: []string{"Fast."}})
+	if resp, body := do(t, "POST", srv.URL+"/api/chat/stream", ChatRequest{SessionID: "second", Message: "hi"}); resp.StatusCode != http.StatusOK {
+		t.Errorf("after the first stream ended: status = %d, body %s", resp.StatusCode, body)
+	}
+}
+
+func TestStreamTTFT(t *testing.T) {
+	srv, _ := newTestServer(t, cerebrastest.Response{Stream: []string{"Late ", "start ", "of ", "a reply."}, ChunkDelay: 100 * time.Millisecond})
+	logs := captureLogs(t)
+	count0, sum0, _ := histogramState(t, streamTTFT)
+
+	resp, body := do(t, "POST", srv.URL+"/api/chat/stream", ChatRequest{SessionID: "ttft", Message: "hi"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+	}
+
+	count, sum, _ := histogramState(t, streamTTFT)
+	if count != count0+1 {
+		t.Fatalf("TTFT histogram got %d observations, want 1", count-count0)
+	}
+	// only the first token's delay, not the whole stream's
+	if got := sum - sum0; got < 0.1 || got >= 0.3 {
+		t.Errorf("observed TTFT %.3fs, want about the 100ms first-token delay", got)
+	}
+	ms, err := strconv.Atoi(resp.Trailer.Get("X-TTFT-Ms"))
+	if err != nil || ms < 100 || ms >= 300 {
+		t.Errorf("X-TTFT-Ms trailer = %q", resp.Trailer.Get("X-TTFT-Ms"))
+	}
+	if recs := logs.records(t, "turn completed"); len(recs) != 1 || recs[0]["ttft_ms"] != float64(ms) {
+		t.Errorf("turn records = %v, want ttft_ms %d", recs, ms)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestTracingSpans(t *testing.T) {
+	srv, _ := newTestServer(t, cerebrastest.Response{
+		Content: "Traced.",
+		Usage:   &cerebrastest.Usage{PromptTokens: 10, CompletionTokens: 3, TotalTokens: 13},
+	})
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	setVar(t, &tracer, tp.Tracer("cerebraschat"))
+
+	req, _ := http.NewRequest("POST", srv.URL+"/api/chat", strings.NewReader(`{"session_id": "traced", "message": "hi"}`))
+	if resp, body := send(t, req); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+	}
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, s := range exporter.GetSpans() {
+		spans[s.Name] = s
+	}
+	server, ok := spans["POST /api/chat"]
+	if !ok {
+		t.Fatalf("no server span among %v", exporter.GetSpans())
+	}
+	client, ok := spans["cerebras.chat.completions"]
+	if !ok {
+		t.Fatalf("no upstream span among %v", exporter.GetSpans())
+	}
+	if client.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Error("upstream span isn't a child of the request span")
+	}
+
+	want := map[attribute.Key]attribute.Value{
+		"llm.model":                   attribute.StringValue("gpt-oss-120b"),
+		"llm.usage.prompt_tokens":     attribute.IntValue(10),
+		"llm.usage.completion_tokens": attribute.IntValue(3),
+		"llm.usage.total_tokens":      attribute.IntValue(13),
+		"http.response.status_code":   attribute.IntValue(200),
+	}
+	got := map[attribute.Key]attribute.Value{}
+	for _, kv := range client.Attributes {
+		got[kv.Key] = kv.Value
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("upstream span %s = %v, want %v", k, got[k].Emit(), v.Emit())
+		}
+	}
+	for _, kv := range server.Attributes {
+		if kv.Key == "http.response.status_code" && kv.Value.AsInt64() != 200 {
+			t.Errorf("request span status = %d", kv.Value.AsInt64())
+		}
+	}
+}
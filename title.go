@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+const titleInstruction = "Write a short title, at most six words, for the conversation above. Reply with the title only: no quotes, no trailing punctuation."
+
+type TitleReply struct {
+	SessionID string `json:"session_id"`
+	Title     string `json:"title"`
+	// Cached marks a title reused from an earlier request.
+	Cached bool `json:"cached,omitempty"`
+}
+
+// handleTitle returns a short title for a session's conversation, for
+// listing past chats. It is generated by a one-shot completion that never
+// touches the session's history or turn count, and reused until
+// TITLE_REFRESH_TURNS more user turns have happened or the session is
+// reset.
+func handleTitle(w http.ResponseWriter, r *http.Request) {
+	id := sessionKey(withCookieSession(w, r, r.URL.Query().Get("session_id")))
+	sess, ok, err := findSession(id)
+	if err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	if !ok {
+		writeErrorStatus(w, r, http.StatusNotFound, "session not found")
+		return
+	}
+
+	sess.mu.RLock()
+	title, turns := sess.title, sess.userTurns
+	fresh := title != "" && turns-sess.titleTurns < envInt("TITLE_REFRESH_TURNS", 3)
+	msgs := withoutSystem(sess.history.slice())
+	model, lastID, size := sess.model, sess.lastID, sess.history.len()
+	sess.mu.RUnlock()
+
+	if fresh {
+		writeJSON(w, r, http.StatusOK, TitleReply{SessionID: id, Title: title, Cached: true})
+		return
+	}
+	if len(msgs) == 0 {
+		writeErrorStatus(w, r, http.StatusBadRequest, "conversation is empty")
+		return
+	}
+
+	// the session lock isn't held during the completion, so a title
+	// request never holds up a turn
+	for i := range msgs {
+		msgs[i].ID, msgs[i].Truncated = "", false
+	}
+	msgs = append(msgs, Message{Role: "user", Content: titleInstruction})
+	params := defaultParams.merge(GenParams{Model: model, MaxTokens: envInt("TITLE_MAX_TOKENS", 24)})
+	apiRes, err := complete(r.Context(), msgs, params)
+	if err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+	title = cleanTitle(apiRes.Choices[0].Message.Content)
+
+	// the conversation may have moved on meanwhile: a title for one that
+	// has since been reset, edited, trimmed or deleted isn't kept
+	sess.mu.Lock()
+	unchanged := sess.userTurns == turns && sess.lastID == lastID && sess.history.len() == size
+	if current, ok, _ := findSession(id); unchanged && ok && current == sess {
+		sess.title, sess.titleTurns = title, turns
+		saveSession(id, sess)
+	}
+	sess.mu.Unlock()
+
+	writeJSON(w, r, http.StatusOK, TitleReply{SessionID: id, Title: title})
+}
+
+// cleanTitle strips what models tend to wrap titles in despite being
+// asked not to.
+func cleanTitle(s string) string {
+	s = firstLine(s)
+	s = strings.TrimPrefix(s, "Title:")
+	s = strings.Trim(s, " \t\"'`*#")
+	return strings.TrimRight(s, ".!")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestSessionTitle(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+	title := func() TitleReply {
+		t.Helper()
+		resp, body := do(t, "GET", srv.URL+"/api/session/title?session_id=trip", nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("title: status = %d, body %s", resp.StatusCode, body)
+		}
+		var reply TitleReply
+		if err := json.Unmarshal(body, &reply); err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+
+	if resp, _ := do(t, "GET", srv.URL+"/api/session/title?session_id=trip", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown session: status = %d, want 404", resp.StatusCode)
+	}
+	do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "trip", Message: "Where should I go in Lisbon?"})
+
+	upstream.Enqueue(cerebrastest.Response{Content: `Title: "Planning a Lisbon Trip."`})
+	if got := title(); got.Title != "Planning a Lisbon Trip" || got.Cached {
+		t.Errorf("title = %+v, want a fresh cleaned-up title", got)
+	}
+	req := upstream.LastRequest()
+	sent := sentMessages(t, req)
+	if last := sent[len(sent)-1]; last.Content != titleInstruction || sent[0].Role == "system" {
+		t.Errorf("title payload = %+v, want the history without system and the instruction last", sent)
+	}
+	if req.Body["max_tokens"] != float64(24) {
+		t.Errorf("max_tokens = %v, want 24", req.Body["max_tokens"])
+	}
+	if got := len(history(t, srv.URL, "trip")); got != 2 {
+		t.Errorf("history has %d messages after a title, want 2", got)
+	}
+
+	// reused until enough turns have passed
+	calls := len(upstream.Requests())
+	if got := title(); got.Title != "Planning a Lisbon Trip" || !got.Cached {
+		t.Errorf("second title = %+v, want the cached one", got)
+	}
+	if len(upstream.Requests()) != calls {
+		t.Error("cached title called upstream")
+	}
+
+	upstream.Enqueue(cerebrastest.Response{Content: "Ok."})
+	for i := 0; i < 3; i++ {
+		do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "trip", Message: "more"})
+	}
+	upstream.Enqueue(cerebrastest.Response{Content: "Lisbon Food Tour"})
+	if got := title(); got.Title != "Lisbon Food Tour" || got.Cached {
+		t.Errorf("title after three turns = %+v, want a new one", got)
+	}
+}
+
+func TestTitleDiscardedWhenConversationMoves(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+
+	for _, tc := range []struct {
+		name   string
+		change func(id string)
+	}{
+		{"reset", func(id string) { do(t, "POST", srv.URL+"/api/reset", CancelRequest{SessionID: id}) }},
+		{"new turn", func(id string) { do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: id, Message: "more"}) }},
+		{"deleted", func(id string) { do(t, "DELETE", srv.URL+"/api/session?session_id="+id, nil) }},
+	} {
+		id := "moved-" + strings.ReplaceAll(tc.name, " ", "-")
+		do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: id, Message: "hi"})
+
+		upstream.Enqueue(cerebrastest.Response{Content: "Stale Title", Delay: 200 * time.Millisecond}, cerebrastest.Response{Content: "Ok."})
+		calls := len(upstream.Requests())
+		done := make(chan TitleReply)
+		go func() {
+			var reply TitleReply
+			_, body := do(t, "GET", srv.URL+"/api/session/title?session_id="+id, nil)
+			json.Unmarshal(body, &reply)
+			done <- reply
+		}()
+		waitFor(t, "the title request to reach upstream", func() bool { return len(upstream.Requests()) > calls })
+		tc.change(id)
+
+		if got := <-done; got.Title != "Stale Title" {
+			t.Errorf("%s: title reply = %+v, want the generated title still returned", tc.name, got)
+		}
+		sess, ok, _ := findSession(id)
+		if tc.name == "deleted" {
+			if ok {
+				t.Errorf("%s: title brought the session back", tc.name)
+			}
+			continue
+		}
+		sess.mu.RLock()
+		cached := sess.title
+		sess.mu.RUnlock()
+		if cached != "" {
+			t.Errorf("%s: cached title %q for a conversation that moved on", tc.name, cached)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+// estimateTokens gives a rough token count for msgs using the common
+// ~4 characters per token heuristic plus a small per-message overhead.
+// It is only used for coarse budget checks, never for billing.
+func estimateTokens(msgs []Message) int {
+	n := 0
+	for _, m := range msgs {
+		n += 4 + (len(m.Content)+3)/4
+	}
+	return n
+}
+
+// trimToBudget drops the oldest history messages until what remains fits
+// in HISTORY_TOKEN_BUDGET. The system prompt is accounted separately: it
+// gets SYSTEM_TOKEN_RESERVE tokens (or its own estimate, if larger) set
+// aside up front, and only the conversational turns are trimmed against the
+// rest. The newest message is always kept. A budget <= 0 disables trimming.
+func trimToBudget(system string, history []Message) []Message {
+	budget := envInt("HISTORY_TOKEN_BUDGET", 0)
+	if budget <= 0 || len(history) == 0 {
+		return history
+	}
+
+	reserve := envInt("SYSTEM_TOKEN_RESERVE", 0)
+	if system != "" {
+		if n := estimateTokens([]Message{{Role: "system", Content: system}}); n > reserve {
+			reserve = n
+		}
+	}
+	remaining := budget - reserve
+
+	start := len(history) - 1
+	used := estimateTokens(history[start:])
+	for start > 0 {
+		n := estimateTokens(history[start-1 : start])
+		if used+n > remaining {
+			break
+		}
+		used += n
+		start--
+	}
+	return history[start:]
+}
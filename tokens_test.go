@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTrimToBudgetReservesSystem(t *testing.T) {
+	system := strings.Repeat("x", 400) // ~104 tokens
+	history := make([]Message, 6)
+	for i := range history {
+		history[i] = Message{Role: "user", Content: fmt.Sprintf("%-40d", i)} // 14 tokens each
+	}
+	t.Setenv("HISTORY_TOKEN_BUDGET", "150")
+
+	// 150 - 104 leaves room for three turns
+	if got := len(trimToBudget(system, history)); got != 3 {
+		t.Errorf("kept %d turns under the system prompt's own estimate, want 3", got)
+	}
+	// a reserve larger than the prompt wins
+	t.Setenv("SYSTEM_TOKEN_RESERVE", "125")
+	if got := len(trimToBudget(system, history)); got != 1 {
+		t.Errorf("kept %d turns with a 125-token reserve, want 1", got)
+	}
+	// the newest turn is always kept
+	t.Setenv("SYSTEM_TOKEN_RESERVE", "1000")
+	kept := trimToBudget(system, history)
+	if len(kept) != 1 || kept[0] != history[5] {
+		t.Errorf("kept %v, want only the newest turn", kept)
+	}
+	// without a system prompt only the reserve is set aside
+	t.Setenv("SYSTEM_TOKEN_RESERVE", "0")
+	if got := len(trimToBudget("", history)); got != 6 {
+		t.Errorf("kept %d turns without a system prompt, want 6", got)
+	}
+}
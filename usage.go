@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// UnmarshalJSON accepts token counts as integers, floats or numeric
+// strings, since upstream versions differ. A count that is missing, null
+// or not a number reads as 0 rather than failing the whole response.
+func (u *Usage) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		PromptTokens     json.RawMessage `json:"prompt_tokens"`
+		CompletionTokens json.RawMessage `json:"completion_tokens"`
+		TotalTokens      json.RawMessage `json:"total_tokens"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	u.PromptTokens = tokenCount(raw.PromptTokens)
+	u.CompletionTokens = tokenCount(raw.CompletionTokens)
+	u.TotalTokens = tokenCount(raw.TotalTokens)
+	return nil
+}
+
+// tokenCount parses one usage field, rounding fractional values.
+func tokenCount(raw json.RawMessage) int {
+	s := strings.TrimSpace(string(raw))
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = strings.TrimSpace(unquoted)
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) || f < 0 {
+		return 0
+	}
+	return int(math.Round(f))
+}
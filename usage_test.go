@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestUsageNumberFormats(t *testing.T) {
+	for _, tc := range []struct {
+		name, json string
+		want       Usage
+	}{
+		{"integers", `{"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15}`, Usage{10, 5, 15}},
+		{"floats", `{"prompt_tokens": 10.0, "completion_tokens": 4.6, "total_tokens": 1.5e1}`, Usage{10, 5, 15}},
+		{"strings", `{"prompt_tokens": "10", "completion_tokens": " 5 ", "total_tokens": "15.0"}`, Usage{10, 5, 15}},
+		{"junk", `{"prompt_tokens": null, "completion_tokens": "many", "total_tokens": -3}`, Usage{}},
+		{"missing", `{}`, Usage{}},
+	} {
+		var got Usage
+		if err := json.Unmarshal([]byte(tc.json), &got); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+
+	// a response with string counts still counts toward the totals
+	srv, _ := newTestServer(t, cerebrastest.Response{
+		Body: `{"choices": [{"message": {"role": "assistant", "content": "Hi."}}],
+			"usage": {"prompt_tokens": "12", "completion_tokens": 3.0, "total_tokens": "15"}}`,
+	})
+	tokenStats.prompt.Store(0)
+	tokenStats.completion.Store(0)
+	if resp, body := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "usage", Message: "hi"}); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, body)
+	}
+	if p, c := tokenStats.prompt.Load(), tokenStats.completion.Load(); p != 12 || c != 3 {
+		t.Errorf("recorded %d prompt and %d completion tokens, want 12 and 3", p, c)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"unicode/utf8"
+)
+
+// FieldError is a validation failure pinned to the request field that
+// caused it, using JSON paths like "messages[2].role".
+type FieldError struct {
+	Field string
+	Msg   string
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Msg
+}
+
+func invalid(field, format string, args ...interface{}) error {
+	return &FieldError{Field: field, Msg: fmt.Sprintf(format, args...)}
+}
+
+// writeInvalid answers 400 for err, naming the offending field when err
+// is (or wraps) a FieldError. Session store failures get a 503 instead.
+func writeInvalid(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errSessionStore) {
+		writeErrorStatus(w, r, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	var fe *FieldError
+	if errors.As(err, &fe) {
+		writeErrorReply(w, r, http.StatusBadRequest, ChatReply{Error: fe.Msg, Field: fe.Field})
+		return
+	}
+	writeErrorStatus(w, r, http.StatusBadRequest, err.Error())
+}
+
+// validateGenParams checks the sampling fields shared by every endpoint.
+func validateGenParams(p GenParams) error {
+	if p.TopK != nil && *p.TopK < 1 {
+		return invalid("top_k", "must be at least 1")
+	}
+	if p.MinP != nil && (*p.MinP < 0 || *p.MinP > 1) {
+		return invalid("min_p", "must be between 0 and 1")
+	}
+	if rf := p.ResponseFormat; rf != nil && rf.Type != "text" && rf.Type != "json_object" {
+		return invalid("response_format.type", "unsupported type %q (want text or json_object)", rf.Type)
+	}
+	return nil
+}
+
+// validatePromptLength rejects text longer than MAX_PROMPT_CHARS (0, the
+// default, disables the check), saying how much needs to go, which beats
+// the opaque error upstream gives for an over-long prompt.
+func validatePromptLength(field, text string) error {
+	max := envInt("MAX_PROMPT_CHARS", 0)
+	if max <= 0 {
+		return nil
+	}
+	if n := utf8.RuneCountInString(text); n > max {
+		return invalid(field, "is %d characters but the limit is %d; shorten it by at least %d characters", n, max, n-max)
+	}
+	return nil
+}
+
+// decodeJSON decodes the request body into v. With STRICT_JSON_BODY on,
+// anything but whitespace after the JSON value is an error, so client bugs
+// that send concatenated or corrupted bodies don't pass silently.
+func decodeJSON(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if envBool("STRICT_JSON_BODY", false) {
+		if _, err := dec.Token(); err != io.EOF {
+			return errors.New("unexpected data after the JSON value")
+		}
+	}
+	return nil
+}
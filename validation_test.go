@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cerebraschat/internal/cerebrastest"
+)
+
+func TestFieldPaths(t *testing.T) {
+	srv, upstream := newTestServer(t)
+	t.Setenv("MAX_PROMPT_CHARS", "20")
+	zero, tooHigh := 0, 1.5
+
+	for _, tc := range []struct {
+		path  string
+		body  interface{}
+		field string
+	}{
+		{"/api/chat/stateless", StatelessRequest{Messages: []Message{
+			{Role: "user", Content: "a"}, {Role: "assistant", Content: "b"}, {Role: "wizard", Content: "c"},
+		}}, "messages[2].role"},
+		{"/api/chat/stateless", StatelessRequest{Messages: []Message{
+			{Role: "user", Content: "this one is far too long"},
+		}}, "messages[0].content"},
+		{"/api/chat/stateless", StatelessRequest{}, "messages"},
+		{"/api/chat", ChatRequest{SessionID: "v", Message: "hi", GenParams: GenParams{ResponseFormat: &ResponseFormat{Type: "yaml"}}}, "response_format.type"},
+		{"/api/chat", ChatRequest{SessionID: "v", Message: "hi", GenParams: GenParams{TopK: &zero}}, "top_k"},
+		{"/api/chat/stateless", StatelessRequest{Messages: []Message{{Role: "user", Content: "hi"}}, GenParams: GenParams{MinP: &tooHigh}}, "min_p"},
+		{"/api/chat", ChatRequest{SessionID: "bad id", Message: "hi"}, "session_id"},
+	} {
+		resp, body := do(t, "POST", srv.URL+tc.path, tc.body)
+		var reply ChatReply
+		json.Unmarshal(body, &reply)
+		if resp.StatusCode != http.StatusBadRequest || reply.Field != tc.field || reply.Error == "" {
+			t.Errorf("%s %s: status = %d, reply = %+v, want field %s", tc.path, tc.field, resp.StatusCode, reply, tc.field)
+		}
+	}
+	if n := len(upstream.Requests()); n != 0 {
+		t.Errorf("invalid requests reached upstream %d times", n)
+	}
+}
+
+func TestMaxPromptChars(t *testing.T) {
+	srv, upstream := newTestServer(t, cerebrastest.Response{Content: "Ok."})
+	long := strings.Repeat("é", 30)
+
+	if resp, _ := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "long", Message: long}); resp.StatusCode != http.StatusOK {
+		t.Errorf("no limit by default: status = %d", resp.StatusCode)
+	}
+
+	t.Setenv("MAX_PROMPT_CHARS", "25")
+	calls := len(upstream.Requests())
+	for _, path := range []string{"/api/chat", "/api/chat/stream"} {
+		resp, body := do(t, "POST", srv.URL+path, ChatRequest{SessionID: "long", Message: long})
+		var reply ChatReply
+		json.Unmarshal(body, &reply)
+		if resp.StatusCode != http.StatusBadRequest || reply.Field != "message" {
+			t.Errorf("%s: status = %d, reply = %+v", path, resp.StatusCode, reply)
+		}
+		// counted in characters, not bytes
+		if reply.Error != "is 30 characters but the limit is 25; shorten it by at least 5 characters" {
+			t.Errorf("%s: error = %q", path, reply.Error)
+		}
+	}
+	if len(upstream.Requests()) != calls {
+		t.Error("over-long prompt reached upstream")
+	}
+	if resp, _ := do(t, "POST", srv.URL+"/api/chat", ChatRequest{SessionID: "long", Message: long[:50]}); resp.StatusCode != http.StatusOK {
+		t.Errorf("at the limit: status = %d", resp.StatusCode)
+	}
+}
+
+func TestStrictJSONBody(t *testing.T) {
+	srv, _ := newTestServer(t, cerebrastest.Response{Content: "Ok.", Stream: []string{"Ok."}})
+	post := func(endpoint, body string) int {
+		t.Helper()
+		req, _ := http.NewRequest("POST", srv.URL+endpoint, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, _ := send(t, req)
+		return resp.StatusCode
+	}
+
+	const junk = `{"session_id": "strict", "message": "hi"} trailing junk`
+	if got := post("/api/chat", junk); got != http.StatusOK {
+		t.Errorf("trailing junk without STRICT_JSON_BODY: status = %d, want 200", got)
+	}
+
+	t.Setenv("STRICT_JSON_BODY", "true")
+	for _, tc := range []struct {
+		endpoint, body string
+		want           int
+	}{
+		{"/api/chat", junk, http.StatusBadRequest},
+		{"/api/chat", `{"session_id": "strict", "message": "hi"}{"message": "again"}`, http.StatusBadRequest},
+		{"/api/chat/stream", junk, http.StatusBadRequest},
+		{"/api/chat", "{\"session_id\": \"strict\", \"message\": \"hi\"}\n\t ", http.StatusOK},
+	} {
+		if got := post(tc.endpoint, tc.body); got != tc.want {
+			t.Errorf("%s %q: status = %d, want %d", tc.endpoint, tc.body, got, tc.want)
+		}
+	}
+}
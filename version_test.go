@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestVersionInfo(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	for _, path := range []string{"/version", "/health"} {
+		_, body := do(t, "GET", srv.URL+path, nil)
+		var fields map[string]string
+		json.Unmarshal(body, &fields)
+		for _, f := range []string{"version", "commit", "build_time", "go_version"} {
+			if fields[f] == "" {
+				t.Errorf("%s: %s missing in %s", path, f, body)
+			}
+		}
+	}
+
+	// test binaries carry no ldflags or VCS stamp
+	info := buildInfo()
+	if info.Version != "dev" || info.Commit != "dev" || info.BuildTime != "dev" {
+		t.Errorf("defaults = %+v, want dev", info)
+	}
+
+	setVar(t, &version, "1.2.3")
+	setVar(t, &commit, "abc123")
+	setVar(t, &buildTime, "2026-01-01T00:00:00Z")
+	if info := buildInfo(); info.Version != "1.2.3" || info.Commit != "abc123" || info.BuildTime != "2026-01-01T00:00:00Z" {
+		t.Errorf("ldflags values not reported: %+v", info)
+	}
+}